@@ -0,0 +1,391 @@
+// Package asyncstore saves sessions in the background, so that responses
+// do not wait for the session backend.
+package asyncstore
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/goincremental/negroni-sessions/internal/writer"
+	gSessions "github.com/gorilla/sessions"
+)
+
+// Policy decides what happens to a save when the queue is full.
+type Policy int
+
+const (
+	// Block makes the request wait until the queue has room.
+	Block Policy = iota
+	// SaveSync saves the session within the request, as if it were not
+	// queued.
+	SaveSync
+	// Drop discards the save, failing it with ErrQueueFull.
+	Drop
+)
+
+// Defaults for a write-behind store.
+const (
+	DefaultWorkers   = 4
+	DefaultQueueSize = 10000
+	DefaultBatchSize = 100
+	DefaultInterval  = 10 * time.Millisecond
+
+	// pruneAfter is how long the time a session was saved is kept, which
+	// must exceed the time taken to load a session.
+	pruneAfter = time.Minute
+)
+
+// ErrQueueFull is the cause of saves discarded under the Drop policy, which
+// fail with ErrBackendUnavailable.
+var ErrQueueFull = errors.New("asyncstore: queue full")
+
+// Option configures a write-behind store.
+type Option func(*asyncStore)
+
+// WithWorkers sets the number of background workers saving sessions.
+func WithWorkers(workers int) Option {
+	return func(s *asyncStore) {
+		s.workers = workers
+	}
+}
+
+// WithQueueSize sets the most sessions waiting to be saved at once, and
+// the policy applied to saves beyond it.
+func WithQueueSize(size int, policy Policy) Option {
+	return func(s *asyncStore) {
+		s.queueSize, s.policy = size, policy
+	}
+}
+
+// WithBatch sets the most sessions a worker takes from the queue at once,
+// and how long it waits after a partial batch for more saves to gather.
+// Longer intervals coalesce more saves of busy sessions into one.
+func WithBatch(size int, interval time.Duration) Option {
+	return func(s *asyncStore) {
+		s.batchSize, s.interval = size, interval
+	}
+}
+
+// Rekeyer is implemented by stores that move some sessions to a new ID
+// when saving them, such as mongostore and dalstore with sessions keyed by
+// a legacy ObjectId. The new ID must reach the client in a new cookie, so
+// such sessions are saved within the request.
+type Rekeyer interface {
+	Rekeys(session *gSessions.Session) bool
+}
+
+// Store is a write-behind session store.
+type Store interface {
+	nSessions.Store
+	// Close stops queueing saves, then waits until queued saves are
+	// written or ctx is done. Saves made after Close are synchronous.
+	Close(ctx context.Context) error
+	// Pending returns the number of sessions waiting to be saved.
+	Pending() int
+	// Logger sets the logger that failed background saves are reported
+	// to. Nothing is logged by the store until it is set.
+	Logger(*slog.Logger)
+}
+
+// New returns a store that queues saves of existing sessions and writes
+// them to store from background workers. Repeated saves of a session
+// while it is queued are coalesced, so only its latest values are written.
+//
+// store must keep session values server side under the ID in the cookie,
+// as mongostore and redisstore do. New sessions and deletions are still
+// saved within the request, as they set or clear the session cookie, and
+// so are sessions that store, as a Rekeyer, reports will move to a new ID.
+// Queued saves do not rewrite the cookie, so it is not renewed by later
+// requests. Wrap store directly, as other decorators hide Rekeyer; with
+// mongostore and dalstore, sessions keyed by a legacy ObjectId would
+// otherwise lose their cookie when moved to a new ID.
+// Sessions loaded while a save of them is pending are given its values.
+//
+// Failed background saves are reported to the logger, and saves still
+// queued are lost if the process exits before Close has drained them.
+func New(store nSessions.Store, options ...Option) Store {
+	s := &asyncStore{
+		Store:     store,
+		workers:   DefaultWorkers,
+		queueSize: DefaultQueueSize,
+		batchSize: DefaultBatchSize,
+		interval:  DefaultInterval,
+		pending:   make(map[string]*job),
+		inFlight:  make(map[string]*job),
+		saved:     make(map[string]time.Time),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	if s.workers < 1 {
+		s.workers = 1
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.wg.Add(s.workers)
+	for i := 0; i < s.workers; i++ {
+		go s.work()
+	}
+	go func() {
+		s.wg.Wait()
+		close(s.done)
+	}()
+	return s
+}
+
+type asyncStore struct {
+	nSessions.Store
+	workers   int
+	queueSize int
+	policy    Policy
+	batchSize int
+	interval  time.Duration
+	logger    *slog.Logger
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  map[string]*job
+	order    []string
+	inFlight map[string]*job
+	saved    map[string]time.Time
+	pruned   time.Time
+	closed   bool
+	wg       sync.WaitGroup
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+type job struct {
+	r       *http.Request
+	session *gSessions.Session
+}
+
+func (s *asyncStore) Logger(logger *slog.Logger) {
+	s.logger = logger
+}
+
+func (s *asyncStore) Pending() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.pending) + len(s.inFlight)
+}
+
+func (s *asyncStore) Close(ctx context.Context) error {
+	s.mu.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.stop)
+		s.cond.Broadcast()
+	}
+	s.mu.Unlock()
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (s *asyncStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(s, name)
+}
+
+// Ping checks the backend of the wrapped store.
+func (s *asyncStore) Ping(ctx context.Context) error {
+	return nSessions.Ping(ctx, s.Store)
+}
+
+// New loads a session from the wrapped store, with the values of any save
+// of it still queued.
+func (s *asyncStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	for attempt := 0; ; attempt++ {
+		start := time.Now()
+		session, err := s.Store.New(r, name)
+		if err != nil || session == nil || session.ID == "" {
+			return session, err
+		}
+		k := key(name, session.ID)
+		s.mu.Lock()
+		queued := s.pending[k]
+		if queued == nil {
+			queued = s.inFlight[k]
+		}
+		saved := s.saved[k]
+		s.mu.Unlock()
+		switch {
+		case queued != nil:
+			session.Values = copyValues(queued.session.Values)
+		case saved.After(start) && attempt == 0:
+			// A save finished while loading, which may have read the
+			// values it replaced
+			continue
+		}
+		return session, err
+	}
+}
+
+// Save queues a save of an existing session, or saves new and deleted
+// sessions, and those moving to a new ID, right away.
+func (s *asyncStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	if session.IsNew || session.ID == "" || session.Options == nil || session.Options.MaxAge < 0 {
+		return s.saveNow(r, w, session)
+	}
+	if rekeyer, ok := s.Store.(Rekeyer); ok && rekeyer.Rekeys(session) {
+		return s.saveNow(r, w, session)
+	}
+
+	k := key(session.Name(), session.ID)
+	snapshot := gSessions.NewSession(session.Store(), session.Name())
+	snapshot.ID = session.ID
+	snapshot.Values = copyValues(session.Values)
+	options := *session.Options
+	snapshot.Options = &options
+	j := &job{r: r.WithContext(context.WithoutCancel(r.Context())), session: snapshot}
+
+	s.mu.Lock()
+	for {
+		switch {
+		case s.closed:
+			s.mu.Unlock()
+			return s.saveNow(r, w, session)
+		case s.pending[k] != nil:
+			// Coalesce with the save already queued
+			s.pending[k] = j
+			s.mu.Unlock()
+			return nil
+		case len(s.pending) < s.queueSize:
+			s.pending[k] = j
+			s.order = append(s.order, k)
+			s.cond.Broadcast()
+			s.mu.Unlock()
+			return nil
+		case s.policy == SaveSync:
+			s.mu.Unlock()
+			return s.saveNow(r, w, session)
+		case s.policy == Drop:
+			s.mu.Unlock()
+			return nSessions.NewError("asyncstore", "save", nSessions.ErrBackendUnavailable, ErrQueueFull)
+		}
+		s.cond.Wait()
+	}
+}
+
+// saveNow saves a session within the request, after any queued save of it
+// so that it is not overwritten by older values.
+func (s *asyncStore) saveNow(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	if session.ID != "" {
+		k := key(session.Name(), session.ID)
+		s.mu.Lock()
+		if s.pending[k] != nil {
+			delete(s.pending, k)
+			s.cond.Broadcast()
+		}
+		for s.inFlight[k] != nil {
+			s.cond.Wait()
+		}
+		s.mu.Unlock()
+	}
+	return s.Store.Save(r, w, session)
+}
+
+func (s *asyncStore) work() {
+	defer s.wg.Done()
+	for {
+		s.mu.Lock()
+		batch := s.take()
+		for len(batch) == 0 {
+			if s.closed && len(s.pending) == 0 {
+				s.mu.Unlock()
+				return
+			}
+			s.cond.Wait()
+			batch = s.take()
+		}
+		s.mu.Unlock()
+
+		for k, j := range batch {
+			err := s.Store.Save(j.r, writer.Discard, j.session)
+			if err != nil {
+				nSessions.LogError(s.logger, j.r, "asyncstore", "save", j.session.Name(), err)
+			}
+			s.mu.Lock()
+			delete(s.inFlight, k)
+			s.saved[k] = time.Now()
+			s.cond.Broadcast()
+			s.mu.Unlock()
+		}
+		s.prune()
+
+		if len(batch) < s.batchSize && s.interval > 0 {
+			timer := time.NewTimer(s.interval)
+			select {
+			case <-s.stop:
+				timer.Stop()
+			case <-timer.C:
+			}
+		}
+	}
+}
+
+// prune forgets when sessions were saved once no load can still overlap
+// the save.
+func (s *asyncStore) prune() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if now.Sub(s.pruned) < pruneAfter {
+		return
+	}
+	for k, t := range s.saved {
+		if now.Sub(t) > pruneAfter {
+			delete(s.saved, k)
+		}
+	}
+	s.pruned = now
+}
+
+// take removes up to a batch of queued saves from the queue, skipping
+// sessions that another worker is saving so that saves of a session stay
+// in order. s.mu must be held.
+func (s *asyncStore) take() map[string]*job {
+	batch := make(map[string]*job)
+	kept := s.order[:0]
+	for _, k := range s.order {
+		j := s.pending[k]
+		switch {
+		case j == nil:
+			// Saved synchronously meanwhile
+		case s.inFlight[k] != nil || len(batch) >= s.batchSize:
+			kept = append(kept, k)
+		default:
+			batch[k] = j
+			delete(s.pending, k)
+			s.inFlight[k] = j
+		}
+	}
+	s.order = kept
+	if len(batch) > 0 {
+		s.cond.Broadcast()
+	}
+	return batch
+}
+
+func key(name, id string) string {
+	return name + "\x00" + id
+}
+
+func copyValues(values map[interface{}]interface{}) map[interface{}]interface{} {
+	c := make(map[interface{}]interface{}, len(values))
+	for k, v := range values {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,124 @@
+package sessions
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// fingerprintKey is the session value key under which the hashed client
+// fingerprint of a bound session is kept.
+const fingerprintKey = "_fingerprint"
+
+// Fingerprint returns a value identifying the client making a request.
+type Fingerprint func(r *http.Request) string
+
+// UserAgentFingerprint fingerprints a client by its User-Agent header.
+func UserAgentFingerprint(r *http.Request) string {
+	return r.UserAgent()
+}
+
+// IPPrefixFingerprint returns a Fingerprint of the network a client connects
+// from, keeping only the leading v4Bits of IPv4 and v6Bits of IPv6 addresses
+// so clients are not rejected when their address changes within it.
+func IPPrefixFingerprint(v4Bits, v6Bits int) Fingerprint {
+	return func(r *http.Request) string {
+		ip := net.ParseIP(clientIP(r))
+		if ip == nil {
+			return ""
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4.Mask(net.CIDRMask(v4Bits, 32)).String()
+		}
+		return ip.Mask(net.CIDRMask(v6Bits, 128)).String()
+	}
+}
+
+// TLSClientCertFingerprint fingerprints a client by the SHA-256 hash of the
+// TLS certificate it presented, if any.
+func TLSClientCertFingerprint(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256(r.TLS.PeerCertificates[0].Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// Fingerprints combines several fingerprints into one.
+func Fingerprints(fingerprints ...Fingerprint) Fingerprint {
+	return func(r *http.Request) string {
+		parts := make([]string, len(fingerprints))
+		for i, f := range fingerprints {
+			parts[i] = f(r)
+		}
+		return strings.Join(parts, "|")
+	}
+}
+
+// Binding binds sessions to the fingerprint of the client that created them,
+// so a session presented by a different client is detected as hijacked.
+type Binding struct {
+	// Fingerprint identifies the client. It defaults to UserAgentFingerprint.
+	Fingerprint Fingerprint
+	// Regenerate replaces a mismatched session with a new, empty one instead
+	// of rejecting the request with 403 Forbidden.
+	Regenerate bool
+	// OnMismatch, if set, is called with the mismatched session before it is
+	// rejected or regenerated.
+	OnMismatch func(r *http.Request, s Session)
+}
+
+func (b *Binding) sum(r *http.Request) string {
+	fingerprint := b.Fingerprint
+	if fingerprint == nil {
+		fingerprint = UserAgentFingerprint
+	}
+	sum := sha256.Sum256([]byte(fingerprint(r)))
+	return hex.EncodeToString(sum[:])
+}
+
+// bind checks the session against the fingerprint of the current client,
+// binding sessions that have none yet. It returns false if the request
+// should be rejected.
+func (s *session) bind(b *Binding) bool {
+	sess := s.Session()
+	if sess == nil {
+		return true
+	}
+	if sess.Values == nil {
+		sess.Values = make(map[interface{}]interface{})
+	}
+	sum := b.sum(s.request)
+	bound, ok := sess.Values[fingerprintKey].(string)
+	if !ok {
+		sess.Values[fingerprintKey] = sum
+		// A new session is saved anyway once it is used; an existing one
+		// must be saved for the binding to hold on later requests
+		if !sess.IsNew {
+			s.written = true
+		}
+		return true
+	}
+	if subtle.ConstantTimeCompare([]byte(bound), []byte(sum)) == 1 {
+		return true
+	}
+
+	if b.OnMismatch != nil {
+		b.OnMismatch(s.request, s)
+	}
+	if !b.Regenerate {
+		return false
+	}
+
+	sess.ID = ""
+	sess.IsNew = true
+	sess.Values = make(map[interface{}]interface{})
+	sess.Values[fingerprintKey] = sum
+	touchMeta(sess, s.request, false)
+	s.written = true
+	s.hook(s.config.Hooks.OnCreate)
+	return true
+}
@@ -0,0 +1,213 @@
+// Package cachestore keeps recently used sessions of a store in a bounded
+// in-process cache, saving a round trip to the store's backend whenever a
+// session is loaded again within a short time.
+package cachestore
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/gob"
+	"net/http"
+	"sync"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	gSessions "github.com/gorilla/sessions"
+)
+
+// Store is a caching session store.
+type Store interface {
+	nSessions.Store
+	// Invalidate drops the session with the given ID from the cache. Call it
+	// when another node reports a change through OnInvalidate.
+	Invalidate(id string)
+	// OnInvalidate sets a function called with the ID of every session this
+	// store saves or deletes, e.g. to publish it to the other nodes of a
+	// deployment so that they Invalidate their copies.
+	OnInvalidate(fn func(id string))
+}
+
+// New returns a store that caches up to size sessions loaded from or saved
+// to store for at most ttl, writing every save through to store.
+//
+// Sessions are cached by the value of their cookie, so the wrapped store
+// must keep its sessions on the server and identify them by a cookie, as
+// mongostore and dalstore do. Without OnInvalidate and Invalidate, nodes
+// may serve a session for up to ttl after it was changed or deleted
+// elsewhere.
+func New(store nSessions.Store, size int, ttl time.Duration) Store {
+	return &cacheStore{
+		Store:   store,
+		size:    size,
+		ttl:     ttl,
+		lru:     list.New(),
+		byToken: make(map[string]*list.Element),
+		byID:    make(map[string]map[*list.Element]bool),
+	}
+}
+
+type cacheStore struct {
+	nSessions.Store
+	size         int
+	ttl          time.Duration
+	onInvalidate func(id string)
+
+	mu      sync.Mutex
+	lru     *list.List
+	byToken map[string]*list.Element
+	byID    map[string]map[*list.Element]bool
+}
+
+type entry struct {
+	token   string
+	id      string
+	values  []byte
+	options gSessions.Options
+	expires time.Time
+}
+
+func (c *cacheStore) OnInvalidate(fn func(id string)) {
+	c.onInvalidate = fn
+}
+
+func (c *cacheStore) Invalidate(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for e := range c.byID[id] {
+		c.remove(e)
+	}
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (c *cacheStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(c, name)
+}
+
+// Ping checks the backend of the wrapped store.
+func (c *cacheStore) Ping(ctx context.Context) error {
+	return nSessions.Ping(ctx, c.Store)
+}
+
+// New returns the cached session for the request's cookie, or loads it
+// from the wrapped store and caches it.
+func (c *cacheStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return c.Store.New(r, name)
+	}
+	if session := c.lookup(cookie.Value, name); session != nil {
+		return session, nil
+	}
+
+	session, err := c.Store.New(r, name)
+	if err == nil && session != nil && !session.IsNew {
+		c.add(cookie.Value, session)
+	}
+	return session, err
+}
+
+// Save writes the session through to the wrapped store, then caches it under
+// the cookie that the wrapped store set.
+func (c *cacheStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	oldID := session.ID
+	err := c.Store.Save(r, w, session)
+
+	c.Invalidate(oldID)
+	if session.ID != oldID {
+		c.Invalidate(session.ID)
+	}
+	if err == nil && session.Options.MaxAge >= 0 {
+		if token := setCookie(w, session.Name()); token != "" {
+			c.add(token, session)
+		}
+	}
+	if c.onInvalidate != nil && oldID != "" {
+		c.onInvalidate(oldID)
+	}
+	return err
+}
+
+// setCookie returns the value of the last cookie called name set on w.
+func setCookie(w http.ResponseWriter, name string) string {
+	var value string
+	for _, cookie := range (&http.Response{Header: w.Header()}).Cookies() {
+		if cookie.Name == name {
+			value = cookie.Value
+		}
+	}
+	return value
+}
+
+func (c *cacheStore) lookup(token, name string) *gSessions.Session {
+	c.mu.Lock()
+	e, ok := c.byToken[token]
+	if !ok {
+		c.mu.Unlock()
+		return nil
+	}
+	ent := e.Value.(*entry)
+	if time.Now().After(ent.expires) {
+		c.remove(e)
+		c.mu.Unlock()
+		return nil
+	}
+	c.lru.MoveToFront(e)
+	c.mu.Unlock()
+
+	// Each request decodes its own copy, so values are not shared
+	session := gSessions.NewSession(c, name)
+	if err := gob.NewDecoder(bytes.NewReader(ent.values)).Decode(&session.Values); err != nil {
+		return nil
+	}
+	options := ent.options
+	session.Options = &options
+	session.ID = ent.id
+	session.IsNew = false
+	return session
+}
+
+func (c *cacheStore) add(token string, session *gSessions.Session) {
+	if c.size <= 0 || session.ID == "" {
+		return
+	}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(session.Values); err != nil {
+		return
+	}
+	ent := &entry{
+		token:   token,
+		id:      session.ID,
+		values:  buf.Bytes(),
+		expires: time.Now().Add(c.ttl),
+	}
+	if session.Options != nil {
+		ent.options = *session.Options
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.byToken[token]; ok {
+		c.remove(e)
+	}
+	e := c.lru.PushFront(ent)
+	c.byToken[token] = e
+	if c.byID[ent.id] == nil {
+		c.byID[ent.id] = make(map[*list.Element]bool)
+	}
+	c.byID[ent.id][e] = true
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove drops e from the cache. c.mu must be held.
+func (c *cacheStore) remove(e *list.Element) {
+	ent := c.lru.Remove(e).(*entry)
+	delete(c.byToken, ent.token)
+	delete(c.byID[ent.id], e)
+	if len(c.byID[ent.id]) == 0 {
+		delete(c.byID, ent.id)
+	}
+}
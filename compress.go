@@ -0,0 +1,141 @@
+package sessions
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/gorilla/securecookie"
+)
+
+// MaxDecompressedSize limits the size of decompressed session values, so
+// that a small payload cannot expand to exhaust memory.
+var MaxDecompressedSize = 1 << 20
+
+// Compressor compresses serialized session values before they are signed
+// or encrypted.
+type Compressor interface {
+	// Marker identifies the compression format in stored payloads. It must
+	// be unique among registered compressors; 0 means uncompressed.
+	Marker() byte
+	Compress(p []byte) ([]byte, error)
+	// Decompress must fail if the result exceeds MaxDecompressedSize.
+	Decompress(p []byte) ([]byte, error)
+}
+
+// CompressionSetter is implemented by stores that can compress session
+// values with a Compressor. Setting it to nil turns compression off.
+type CompressionSetter interface {
+	Compression(Compressor)
+}
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = map[byte]Compressor{}
+)
+
+// RegisterCompressor makes payloads written with c decodable by
+// DecodeValues, whichever Compressor a store currently uses.
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Marker()] = c
+}
+
+func init() {
+	RegisterCompressor(Gzip)
+}
+
+var errUnknownCompression = fmt.Errorf("%w: unknown compression format", ErrTampered)
+
+// EncodeValues serializes session values and encodes them with codecs.
+// If c is not nil the serialized values are compressed first and prefixed
+// with the marker of c; values that do not shrink are stored uncompressed.
+func EncodeValues(name string, values map[interface{}]interface{}, c Compressor,
+	codecs ...securecookie.Codec) (string, error) {
+	if c == nil {
+		return securecookie.EncodeMulti(name, values, codecs...)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte(0)
+	if err := gob.NewEncoder(&buf).Encode(values); err != nil {
+		return "", err
+	}
+	payload := buf.Bytes()
+	compressed, err := c.Compress(payload[1:])
+	if err != nil {
+		return "", err
+	}
+	if len(compressed)+1 < len(payload) {
+		payload = append([]byte{c.Marker()}, compressed...)
+	}
+	return securecookie.EncodeMulti(name, payload, codecs...)
+}
+
+// DecodeValues decodes values encoded by EncodeValues into dst, whether
+// they were compressed with any registered Compressor or not at all.
+func DecodeValues(name, value string, dst *map[interface{}]interface{},
+	codecs ...securecookie.Codec) error {
+	var payload []byte
+	if err := securecookie.DecodeMulti(name, value, &payload, codecs...); err != nil {
+		// Written without compression, as values are not a []byte
+		return securecookie.DecodeMulti(name, value, dst, codecs...)
+	}
+	if len(payload) == 0 {
+		return errUnknownCompression
+	}
+
+	data := payload[1:]
+	if payload[0] != 0 {
+		compressorsMu.RLock()
+		c, ok := compressors[payload[0]]
+		compressorsMu.RUnlock()
+		if !ok {
+			return errUnknownCompression
+		}
+		var err error
+		if data, err = c.Decompress(data); err != nil {
+			return err
+		}
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(dst)
+}
+
+// Gzip compresses session values with gzip.
+var Gzip Compressor = gzipCompressor{}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Marker() byte { return 1 }
+
+func (gzipCompressor) Compress(p []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(p); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(p []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(p))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(io.LimitReader(r, int64(MaxDecompressedSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDecompressedSize {
+		return nil, ErrTooLarge
+	}
+	return data, nil
+}
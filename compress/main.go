@@ -0,0 +1,68 @@
+// Package compress provides zstd and snappy Compressors for session values.
+// Importing it registers both, so payloads written with either can be
+// decoded.
+package compress
+
+import (
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// Zstd compresses session values with Zstandard.
+	Zstd nSessions.Compressor = zstdCompressor{}
+	// Snappy compresses session values with Snappy.
+	Snappy nSessions.Compressor = snappyCompressor{}
+)
+
+func init() {
+	nSessions.RegisterCompressor(Zstd)
+	nSessions.RegisterCompressor(Snappy)
+}
+
+var encoder, _ = zstd.NewWriter(nil)
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) Marker() byte { return 2 }
+
+func (zstdCompressor) Compress(p []byte) ([]byte, error) {
+	return encoder.EncodeAll(p, nil), nil
+}
+
+func (zstdCompressor) Decompress(p []byte) ([]byte, error) {
+	d, err := zstd.NewReader(nil, zstd.WithDecoderMaxMemory(uint64(nSessions.MaxDecompressedSize)),
+		zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	data, err := d.DecodeAll(p, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > nSessions.MaxDecompressedSize {
+		return nil, nSessions.ErrTooLarge
+	}
+	return data, nil
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Marker() byte { return 3 }
+
+func (snappyCompressor) Compress(p []byte) ([]byte, error) {
+	return snappy.Encode(nil, p), nil
+}
+
+func (snappyCompressor) Decompress(p []byte) ([]byte, error) {
+	n, err := snappy.DecodedLen(p)
+	if err != nil {
+		return nil, err
+	}
+	if n > nSessions.MaxDecompressedSize {
+		return nil, nSessions.ErrTooLarge
+	}
+	return snappy.Decode(nil, p)
+}
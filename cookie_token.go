@@ -2,6 +2,7 @@ package sessions
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/sessions"
 )
@@ -32,3 +33,31 @@ func (c *cookieToken) SetToken(rw http.ResponseWriter, name string, value string
 	options *sessions.Options) {
 	http.SetCookie(rw, sessions.NewCookie(name, value, options))
 }
+
+//NewHeaderToken returns a TokenGetSetter that reads the value from a request
+//header and writes it to the same response header, for clients that do not
+//use cookies. A "Bearer " prefix, as used in the Authorization header, is
+//removed.
+func NewHeaderToken(header string) TokenGetSetter {
+	return &headerToken{header}
+}
+
+type headerToken struct {
+	header string
+}
+
+func (h *headerToken) GetToken(req *http.Request, name string) (string, error) {
+	value := req.Header.Get(h.header)
+	if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+		value = value[7:]
+	}
+	if value == "" {
+		return "", ErrNoToken
+	}
+	return value, nil
+}
+
+func (h *headerToken) SetToken(rw http.ResponseWriter, name string, value string,
+	options *sessions.Options) {
+	rw.Header().Set(h.header, value)
+}
@@ -1,17 +1,82 @@
 package cookiestore
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
 	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/gorilla/securecookie"
 	gSessions "github.com/gorilla/sessions"
 )
 
+const (
+	// ChunkSize is the largest value written to a single cookie, leaving
+	// room for the name and attributes within the 4096 bytes browsers allow.
+	ChunkSize = 3800
+	// DefaultMaxSize is the default limit on the encoded size of a session.
+	DefaultMaxSize = 4 * ChunkSize
+
+	// idKey is the session value key under which the session ID is encoded.
+	idKey = "_id"
+)
+
+// Store is a session store that keeps sessions in cookies.
+//
+// Sessions too large for one cookie are split across name, name_1,
+// name_2... cookies and reassembled when loaded.
+//
+// Each session is given an ID, encoded with its values, so that it can be
+// revoked before its cookie expires. Revoking requires a revocation list,
+// shared by every server that loads the sessions; to log a user out
+// everywhere, record the IDs of their sessions and revoke each of them.
+type Store interface {
+	nSessions.Store
+	nSessions.LoggerSetter
+	// MaxSize sets the limit on the encoded size of a session, across all
+	// of its cookies. Saving a larger session fails with ErrTooLarge. Bear
+	// in mind that servers and proxies limit the size of request headers.
+	MaxSize(size int)
+	// Compression sets the compressor applied to session values before they
+	// are signed and encrypted. Cookies written with or without compression
+	// remain readable.
+	Compression(nSessions.Compressor)
+	// Revocation sets the list of revoked session IDs consulted when a
+	// session is loaded. Sessions on the list are loaded as new ones with
+	// ErrRevoked. If the list cannot be consulted the session is loaded as
+	// a new one too, with the error from the list.
+	Revocation(nSessions.Revocation)
+	// Revoke adds a session ID to the revocation list until its cookie
+	// would have expired.
+	Revoke(ctx context.Context, id string) error
+}
+
 // New returns a new CookieStore.
-func New(keyPairs ...[]byte) nSessions.Store {
-	return &cookieStore{gSessions.NewCookieStore(keyPairs...)}
+func New(keyPairs ...[]byte) Store {
+	c := &cookieStore{
+		CookieStore: gSessions.NewCookieStore(keyPairs...),
+		maxSize:     DefaultMaxSize,
+		ids:         nSessions.DefaultIDGenerator,
+	}
+	// Length is limited per cookie by chunking instead
+	for _, codec := range c.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxLength(0)
+		}
+	}
+	return c
 }
 
 type cookieStore struct {
 	*gSessions.CookieStore
+	logger     *slog.Logger
+	maxSize    int
+	compressor nSessions.Compressor
+	revocation nSessions.Revocation
+	ids        nSessions.IDGenerator
 }
 
 func (c *cookieStore) Options(options nSessions.Options) {
@@ -23,3 +88,163 @@ func (c *cookieStore) Options(options nSessions.Options) {
 		HttpOnly: options.HTTPOnly,
 	}
 }
+
+func (c *cookieStore) MaxSize(size int) {
+	c.maxSize = size
+}
+
+func (c *cookieStore) Compression(compressor nSessions.Compressor) {
+	c.compressor = compressor
+}
+
+func (c *cookieStore) Revocation(revocation nSessions.Revocation) {
+	c.revocation = revocation
+}
+
+func (c *cookieStore) Revoke(ctx context.Context, id string) error {
+	if c.revocation == nil {
+		return nSessions.NewError("cookiestore", "revoke", nil, errors.New("no revocation list set"))
+	}
+	var until time.Time
+	if maxAge := c.CookieStore.Options.MaxAge; maxAge > 0 {
+		until = time.Now().Add(time.Duration(maxAge) * time.Second)
+	}
+	return nSessions.WrapError("cookiestore", "revoke", c.revocation.Revoke(ctx, id, until))
+}
+
+// IDGenerator sets the generator of new session IDs.
+func (c *cookieStore) IDGenerator(ids nSessions.IDGenerator) {
+	c.ids = ids
+}
+
+func (c *cookieStore) Logger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (c *cookieStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(c, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (c *cookieStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	session := gSessions.NewSession(c, name)
+	options := *c.CookieStore.Options
+	session.Options = &options
+	session.IsNew = true
+
+	var err error
+	if value := c.readChunks(r, name); value != "" {
+		err = nSessions.DecodeValues(name, value, &session.Values, c.Codecs...)
+		if err == nil {
+			session.ID, _ = session.Values[idKey].(string)
+			delete(session.Values, idKey)
+			err = c.checkRevoked(r, session)
+		}
+		if err == nil {
+			session.IsNew = false
+		} else {
+			session.ID = ""
+			session.Values = make(map[interface{}]interface{})
+		}
+	}
+	if err != nil {
+		err = nSessions.WrapError("cookiestore", "load", err)
+		nSessions.LogError(c.logger, r, "cookiestore", "load", name, err)
+	}
+	return session, err
+}
+
+// Save adds a single session to the response.
+func (c *cookieStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	op := "save"
+	var chunks []string
+	if session.Options.MaxAge < 0 {
+		op = "delete"
+		chunks = []string{""}
+	} else {
+		encoded, err := c.encode(session)
+		if err == nil && c.maxSize > 0 && len(encoded) > c.maxSize {
+			err = nSessions.NewError("cookiestore", op, nSessions.ErrTooLarge, nil)
+		}
+		if err != nil {
+			err = nSessions.WrapError("cookiestore", op, err)
+			nSessions.LogError(c.logger, r, "cookiestore", op, session.Name(), err)
+			return err
+		}
+		chunks = split(encoded, ChunkSize)
+	}
+
+	for i, chunk := range chunks {
+		http.SetCookie(w, gSessions.NewCookie(chunkName(session.Name(), i), chunk, session.Options))
+	}
+
+	// Expire chunks left over from a larger session
+	expired := *session.Options
+	expired.MaxAge = -1
+	for i := len(chunks); ; i++ {
+		if _, err := r.Cookie(chunkName(session.Name(), i)); err != nil {
+			break
+		}
+		http.SetCookie(w, gSessions.NewCookie(chunkName(session.Name(), i), "", &expired))
+	}
+	return nil
+}
+
+func (c *cookieStore) encode(session *gSessions.Session) (string, error) {
+	if session.ID == "" {
+		id, err := c.ids.NewID()
+		if err != nil {
+			return "", err
+		}
+		session.ID = id
+	}
+	session.Values[idKey] = session.ID
+	defer delete(session.Values, idKey)
+	return nSessions.EncodeValues(session.Name(), session.Values, c.compressor, c.Codecs...)
+}
+
+func (c *cookieStore) checkRevoked(r *http.Request, session *gSessions.Session) error {
+	if c.revocation == nil || session.ID == "" {
+		return nil
+	}
+	revoked, err := c.revocation.Revoked(r.Context(), session.ID)
+	switch {
+	case err != nil:
+		return err
+	case revoked:
+		return nSessions.NewError("cookiestore", "load", nSessions.ErrRevoked, nil)
+	}
+	return nil
+}
+
+func chunkName(name string, i int) string {
+	if i == 0 {
+		return name
+	}
+	return name + "_" + strconv.Itoa(i)
+}
+
+// readChunks returns the value of the named cookie, joined with any
+// continuation chunks up to the size limit.
+func (c *cookieStore) readChunks(r *http.Request, name string) string {
+	var value string
+	for i := 0; c.maxSize <= 0 || i <= c.maxSize/ChunkSize; i++ {
+		cookie, err := r.Cookie(chunkName(name, i))
+		if err != nil {
+			return value
+		}
+		value += cookie.Value
+	}
+	return value
+}
+
+func split(value string, size int) []string {
+	chunks := make([]string, 0, len(value)/size+1)
+	for len(value) > size {
+		chunks = append(chunks, value[:size])
+		value = value[size:]
+	}
+	return append(chunks, value)
+}
@@ -1,6 +1,8 @@
 package dalstore
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -10,9 +12,23 @@ import (
 	gSessions "github.com/gorilla/sessions"
 )
 
+// Store is a session store that keeps sessions in a dal database.
+type Store interface {
+	nSessions.Store
+	nSessions.LoggerSetter
+}
+
 // New is returns a store object using the provided dal.Connection
+//
+// Sessions are keyed by IDs from nSessions.DefaultIDGenerator. Sessions
+// created by earlier versions of the store, keyed by an ObjectID, are still
+// loaded and are moved to a new random ID the next time they are saved.
+//
+// Unless ensureTTL is set and the backend supports TTL indexes, expired
+// sessions are kept until they are deleted with DeleteExpired, e.g. by a
+// nSessions.Sweeper.
 func New(connection dal.Connection, database string, collection string, maxAge int,
-	ensureTTL bool, keyPairs ...[]byte) nSessions.Store {
+	ensureTTL bool, keyPairs ...[]byte) Store {
 	if ensureTTL {
 		conn := connection.Clone()
 		defer conn.Close()
@@ -34,6 +50,7 @@ func New(connection dal.Connection, database string, collection string, maxAge i
 		options: &gSessions.Options{
 			MaxAge: maxAge,
 		},
+		ids: nSessions.DefaultIDGenerator,
 	}
 }
 
@@ -47,10 +64,29 @@ func (d *dalStore) Options(options nSessions.Options) {
 	}
 }
 
+// IDGenerator sets the generator of new session IDs.
+func (d *dalStore) IDGenerator(ids nSessions.IDGenerator) {
+	d.ids = ids
+}
+
+// Compression sets the compressor applied to session values before they
+// are stored. Values stored with or without compression remain readable.
+func (d *dalStore) Compression(c nSessions.Compressor) {
+	d.compressor = c
+}
+
 type dalSession struct {
-	ID       dal.ObjectID `bson:"_id,omitempty"`
-	Data     string
-	Modified time.Time
+	ID         interface{} `bson:"_id,omitempty"`
+	Data       string
+	Modified   time.Time
+	Created    time.Time
+	LastAccess time.Time
+	IP         string
+	UserAgent  string
+}
+
+func (d *dalStore) Logger(logger *slog.Logger) {
+	d.logger = logger
 }
 
 type dalStore struct {
@@ -60,6 +96,9 @@ type dalStore struct {
 	database   string
 	collection string
 	options    *gSessions.Options
+	logger     *slog.Logger
+	ids        nSessions.IDGenerator
+	compressor nSessions.Compressor
 }
 
 //Implementation of gorilla/sessions.Store interface
@@ -80,9 +119,13 @@ func (d *dalStore) New(r *http.Request, name string) (*gSessions.Session, error)
 	if cook, errToken := d.Token.GetToken(r, name); errToken == nil {
 		err = securecookie.DecodeMulti(name, cook, &session.ID, d.Codecs...)
 		if err == nil {
-			ok, err := d.load(session)
+			var ok bool
+			ok, err = d.load(session)
 			session.IsNew = !(err == nil && ok) // not new if no error and data available
 		}
+		if err != nil {
+			err = d.fail(r, "load", name, err)
+		}
 	}
 	return session, err
 }
@@ -90,31 +133,67 @@ func (d *dalStore) New(r *http.Request, name string) (*gSessions.Session, error)
 func (d *dalStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
 	if session.Options.MaxAge < 0 {
 		if err := d.delete(session); err != nil {
-			return err
+			return d.fail(r, "delete", session.Name(), err)
 		}
 		d.Token.SetToken(w, session.Name(), "", session.Options)
 		return nil
 	}
+	var legacyID string
+	if dal.IsObjectIDHex(session.ID) {
+		// Move sessions keyed by an ObjectID to a random ID
+		legacyID = session.ID
+		session.ID = ""
+	}
 	if session.ID == "" {
-		session.ID = dal.NewObjectID().Hex()
+		id, err := d.ids.NewID()
+		if err != nil {
+			return d.fail(r, "save", session.Name(), err)
+		}
+		session.ID = id
 	}
 
 	if err := d.save(session); err != nil {
-		return err
+		return d.fail(r, "save", session.Name(), err)
+	}
+
+	if legacyID != "" {
+		if err := d.remove(dal.ObjectIDHex(legacyID)); err != nil && !isNotFound(err) {
+			d.fail(r, "delete", session.Name(), err)
+		}
 	}
 	//save just the id to the cookie, the rest will be saved in the dal store
 	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, d.Codecs...)
 
 	if err != nil {
-		return err
+		return d.fail(r, "save", session.Name(), err)
 	}
 
 	d.Token.SetToken(w, session.Name(), encoded, session.Options)
 	return err
 }
 
+// fail maps err onto the nSessions error kinds and reports it to the logger.
+func (d *dalStore) fail(r *http.Request, op, name string, err error) error {
+	switch {
+	case isNotFound(err):
+		err = nSessions.NewError("dalstore", op, nSessions.ErrNotFound, err)
+	case err.Error() == "no reachable servers":
+		err = nSessions.NewError("dalstore", op, nSessions.ErrBackendUnavailable, err)
+	default:
+		err = nSessions.WrapError("dalstore", op, err)
+	}
+	nSessions.LogError(d.logger, r, "dalstore", op, name, err)
+	return err
+}
+
+// isNotFound reports whether err is the "not found" error the dal backends
+// return for a missing document.
+func isNotFound(err error) bool {
+	return err != nil && err.Error() == "not found"
+}
+
 func (d *dalStore) load(session *gSessions.Session) (bool, error) {
-	if !dal.IsObjectIDHex(session.ID) {
+	if !nSessions.ValidID(session.ID) {
 		return false, nSessions.ErrInvalidId
 	}
 	conn := d.connection.Clone()
@@ -123,18 +202,27 @@ func (d *dalStore) load(session *gSessions.Session) (bool, error) {
 	c := db.C(d.collection)
 
 	s := dalSession{}
-	err := c.FindID(dal.ObjectIDHex(session.ID)).One(&s)
+	err := c.FindID(session.ID).One(&s)
+	if isNotFound(err) && dal.IsObjectIDHex(session.ID) {
+		err = c.FindID(dal.ObjectIDHex(session.ID)).One(&s)
+	}
 	if err != nil {
 		return false, err
 	}
-	if err := securecookie.DecodeMulti(session.Name(), s.Data, &session.Values, d.Codecs...); err != nil {
+	if err := nSessions.DecodeValues(session.Name(), s.Data, &session.Values, d.Codecs...); err != nil {
 		return false, err
 	}
+	nSessions.SetMeta(session, nSessions.Meta{
+		Created:    s.Created,
+		LastAccess: s.LastAccess,
+		IP:         s.IP,
+		UserAgent:  s.UserAgent,
+	})
 	return true, nil
 }
 
 func (d *dalStore) save(session *gSessions.Session) error {
-	if !dal.IsObjectIDHex(session.ID) {
+	if !nSessions.ValidID(session.ID) {
 		return nSessions.ErrInvalidId
 	}
 
@@ -153,19 +241,23 @@ func (d *dalStore) save(session *gSessions.Session) error {
 		modified = time.Now()
 	}
 
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values, d.Codecs...)
+	encoded, err := nSessions.EncodeValues(session.Name(), nSessions.StripMeta(session.Values), d.compressor, d.Codecs...)
 	if err != nil {
 		return err
 	}
 
-	id := dal.ObjectIDHex(session.ID)
+	meta := nSessions.GetMeta(session)
 	s := dalSession{
-		ID:       id,
-		Data:     encoded,
-		Modified: modified,
+		ID:         session.ID,
+		Data:       encoded,
+		Modified:   modified,
+		Created:    meta.Created,
+		LastAccess: meta.LastAccess,
+		IP:         meta.IP,
+		UserAgent:  meta.UserAgent,
 	}
 
-	_, err = c.SaveID(id, &s)
+	_, err = c.SaveID(session.ID, &s)
 	if err != nil {
 		return err
 	}
@@ -173,15 +265,55 @@ func (d *dalStore) save(session *gSessions.Session) error {
 	return nil
 }
 
+// Rekeys reports whether saving session moves it to a new ID, as it is
+// keyed by a legacy ObjectId.
+func (d *dalStore) Rekeys(session *gSessions.Session) bool {
+	return dal.IsObjectIDHex(session.ID)
+}
+
+// Ping checks that the database can be reached by looking up a session
+// that does not exist.
+func (d *dalStore) Ping(ctx context.Context) error {
+	conn := d.connection.Clone()
+	defer conn.Close()
+	var s dalSession
+	err := conn.DB(d.database).C(d.collection).FindID("ping").One(&s)
+	if err != nil && !isNotFound(err) {
+		return nSessions.NewError("dalstore", "ping", nSessions.ErrBackendUnavailable, err)
+	}
+	return nil
+}
+
+// DeleteExpired deletes the sessions last modified before the given time,
+// for backends without TTL indexes or when ensureTTL is not set.
+func (d *dalStore) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	conn := d.connection.Clone()
+	defer conn.Close()
+	c := conn.DB(d.database).C(d.collection)
+	info, err := c.RemoveAll(map[string]interface{}{
+		"modified": map[string]interface{}{"$lt": before},
+	})
+	if err != nil {
+		return 0, nSessions.WrapError("dalstore", "sweep", err)
+	}
+	return info.Removed, nil
+}
+
 func (d *dalStore) delete(session *gSessions.Session) error {
-	if !dal.IsObjectIDHex(session.ID) {
+	if !nSessions.ValidID(session.ID) {
 		return nSessions.ErrInvalidId
 	}
+	if dal.IsObjectIDHex(session.ID) {
+		return d.remove(dal.ObjectIDHex(session.ID))
+	}
+	return d.remove(session.ID)
+}
 
+func (d *dalStore) remove(id interface{}) error {
 	conn := d.connection.Clone()
 	defer conn.Close()
 	db := conn.DB(d.database)
 	c := db.C(d.collection)
 
-	return c.RemoveID(dal.ObjectIDHex(session.ID))
+	return c.RemoveID(id)
 }
@@ -1,23 +1,50 @@
 package dynamostore
 
 import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	dynstore "github.com/denizeren/dynamostore"
 	nSessions "github.com/goincremental/negroni-sessions"
 	gSessions "github.com/gorilla/sessions"
 )
 
 //New returns a new Dynamodb store
-func New(accessKey string, secretKey string, tableName string, region string, keyPairs ...[]byte) (nSessions.Store, error) {
+//
+//Deprecated: New requires static credentials. Use NewWithClient, which
+//accepts a client configured from the AWS credential chain.
+func New(accessKey string, secretKey string, tableName string, region string, keyPairs ...[]byte) (Store, error) {
 	store, err := dynstore.NewDynamoStore(accessKey, secretKey, tableName, region, keyPairs...)
 
 	if err != nil {
 		return nil, err
 	}
-	return &dynamoStore{store}, nil
+	// The wrapped store keeps its client to itself, so Ping uses its own
+	client := dynamodb.NewFromConfig(aws.Config{
+		Region:      region,
+		Credentials: credentials.NewStaticCredentialsProvider(accessKey, secretKey, ""),
+	})
+	return &dynamoStore{DynamoStore: store, client: client, table: tableName}, nil
 }
 
 type dynamoStore struct {
 	*dynstore.DynamoStore
+	client *dynamodb.Client
+	table  string
+	logger *slog.Logger
+}
+
+// Ping checks that the table can be reached by describing it.
+func (c *dynamoStore) Ping(ctx context.Context) error {
+	_, err := c.client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(c.table)})
+	if err != nil {
+		return nSessions.NewError("dynamostore", "ping", nSessions.ErrBackendUnavailable, err)
+	}
+	return nil
 }
 
 func (c *dynamoStore) Options(options nSessions.Options) {
@@ -29,3 +56,35 @@ func (c *dynamoStore) Options(options nSessions.Options) {
 		HttpOnly: options.HTTPOnly,
 	}
 }
+
+func (c *dynamoStore) Logger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (c *dynamoStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(c, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (c *dynamoStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	session, err := c.DynamoStore.New(r, name)
+	err = nSessions.WrapError("dynamostore", "load", err)
+	nSessions.LogError(c.logger, r, "dynamostore", "load", name, err)
+	return session, err
+}
+
+// Save adds a single session to the response.
+func (c *dynamoStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	err := c.DynamoStore.Save(r, w, session)
+	if err != nil {
+		op := "save"
+		if session.Options != nil && session.Options.MaxAge < 0 {
+			op = "delete"
+		}
+		err = nSessions.WrapError("dynamostore", op, err)
+		nSessions.LogError(c.logger, r, "dynamostore", op, session.Name(), err)
+	}
+	return err
+}
@@ -0,0 +1,345 @@
+package dynamostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/gorilla/securecookie"
+	gSessions "github.com/gorilla/sessions"
+)
+
+// Attribute names of session items. The table is keyed by AttrID alone,
+// and AttrExpires holds the Unix time used as its TTL attribute.
+const (
+	AttrID         = "id"
+	AttrData       = "data"
+	AttrModified   = "modified"
+	AttrCreated    = "created"
+	AttrLastAccess = "last_access"
+	AttrIP         = "ip"
+	AttrUserAgent  = "user_agent"
+	AttrExpires    = "expires"
+)
+
+// Client is the subset of *dynamodb.Client used by the store.
+type Client interface {
+	GetItem(ctx context.Context, params *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+	PutItem(ctx context.Context, params *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	DeleteItem(ctx context.Context, params *dynamodb.DeleteItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error)
+}
+
+// NewClient returns a DynamoDB client configured from the default AWS
+// credential chain: environment variables, shared configuration files and
+// IAM roles. A non-empty endpoint overrides the service endpoint, e.g.
+// "http://localhost:8000" for DynamoDB Local.
+func NewClient(ctx context.Context, endpoint string,
+	optFns ...func(*config.LoadOptions) error) (*dynamodb.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	return dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+	}), nil
+}
+
+// Store is a session store that keeps sessions in DynamoDB.
+type Store interface {
+	nSessions.Store
+	nSessions.LoggerSetter
+}
+
+// NewWithClient returns a DynamoDB store that keeps sessions in the given
+// table through client, which is usually created with NewClient.
+//
+// Sessions expire through the table's TTL attribute, AttrExpires, which is
+// set MaxAge seconds after each save. As DynamoDB removes expired items
+// lazily, expired items are also ignored when loading. New sessions are
+// written only if their ID is unused, failing with ErrConflict otherwise,
+// and existing sessions only if they have not been deleted meanwhile, e.g.
+// by a logout in another request, failing with ErrNotFound otherwise.
+func NewWithClient(client Client, table string, keyPairs ...[]byte) Store {
+	return &nativeStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Token:  nSessions.NewCookieToken(),
+		client: client,
+		table:  table,
+		options: &gSessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		ids: nSessions.DefaultIDGenerator,
+	}
+}
+
+type nativeStore struct {
+	Codecs     []securecookie.Codec
+	Token      nSessions.TokenGetSetter
+	client     Client
+	table      string
+	options    *gSessions.Options
+	logger     *slog.Logger
+	ids        nSessions.IDGenerator
+	compressor nSessions.Compressor
+}
+
+func (d *nativeStore) Options(options nSessions.Options) {
+	d.options = &gSessions.Options{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HTTPOnly,
+	}
+}
+
+// IDGenerator sets the generator of new session IDs.
+func (d *nativeStore) IDGenerator(ids nSessions.IDGenerator) {
+	d.ids = ids
+}
+
+// Compression sets the compressor applied to session values before they
+// are stored. Values stored with or without compression remain readable.
+func (d *nativeStore) Compression(c nSessions.Compressor) {
+	d.compressor = c
+}
+
+func (d *nativeStore) Logger(logger *slog.Logger) {
+	d.logger = logger
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (d *nativeStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(d, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (d *nativeStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	session := gSessions.NewSession(d, name)
+	options := *d.options
+	session.Options = &options
+	session.IsNew = true
+	var err error
+	if cook, errToken := d.Token.GetToken(r, name); errToken == nil {
+		err = securecookie.DecodeMulti(name, cook, &session.ID, d.Codecs...)
+		if err == nil {
+			err = d.load(r.Context(), session)
+			session.IsNew = err != nil
+		}
+		if err != nil {
+			// A new session must not take on an ID chosen by the client
+			session.ID = ""
+			err = d.fail(r, "load", name, err)
+		}
+	}
+	return session, err
+}
+
+// Save adds a single session to the response.
+func (d *nativeStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if err := d.delete(r.Context(), session); err != nil {
+			return d.fail(r, "delete", session.Name(), err)
+		}
+		d.Token.SetToken(w, session.Name(), "", session.Options)
+		return nil
+	}
+
+	isNew := session.IsNew || session.ID == ""
+	if session.ID == "" {
+		id, err := d.ids.NewID()
+		if err != nil {
+			return d.fail(r, "save", session.Name(), err)
+		}
+		session.ID = id
+	}
+	if err := d.save(r.Context(), session, isNew); err != nil {
+		var conditional *types.ConditionalCheckFailedException
+		if isNew && errors.As(err, &conditional) {
+			err = fmt.Errorf("%w: %w", errIDTaken, err)
+		}
+		return d.fail(r, "save", session.Name(), err)
+	}
+	session.IsNew = false
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, d.Codecs...)
+	if err != nil {
+		return d.fail(r, "save", session.Name(), err)
+	}
+	d.Token.SetToken(w, session.Name(), encoded, session.Options)
+	return nil
+}
+
+// fail maps err onto the nSessions error kinds and reports it to the logger.
+func (d *nativeStore) fail(r *http.Request, op, name string, err error) error {
+	var conditional *types.ConditionalCheckFailedException
+	var throttled *types.ProvisionedThroughputExceededException
+	switch {
+	case errors.Is(err, errIDTaken):
+		err = nSessions.NewError("dynamostore", op, nSessions.ErrConflict, err)
+	case errors.Is(err, errNotFound) || errors.As(err, &conditional):
+		err = nSessions.NewError("dynamostore", op, nSessions.ErrNotFound, err)
+	case errors.As(err, &throttled):
+		err = nSessions.NewError("dynamostore", op, nSessions.ErrBackendUnavailable, err)
+	default:
+		err = nSessions.WrapError("dynamostore", op, err)
+	}
+	nSessions.LogError(d.logger, r, "dynamostore", op, name, err)
+	return err
+}
+
+// Ping checks that the table can be read, by looking up a session that does
+// not exist.
+func (d *nativeStore) Ping(ctx context.Context) error {
+	_, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(d.table),
+		Key:       key("ping"),
+	})
+	if err != nil {
+		return nSessions.NewError("dynamostore", "ping", nSessions.ErrBackendUnavailable, err)
+	}
+	return nil
+}
+
+var (
+	errNotFound = errors.New("dynamostore: no such item")
+	// errIDTaken is returned when a new session is given the ID of one
+	// already stored.
+	errIDTaken = errors.New("dynamostore: session ID already taken")
+)
+
+func (d *nativeStore) load(ctx context.Context, session *gSessions.Session) error {
+	if !nSessions.ValidID(session.ID) {
+		return nSessions.ErrInvalidId
+	}
+	out, err := d.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(d.table),
+		Key:            key(session.ID),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return err
+	}
+	item := out.Item
+	if item == nil {
+		return errNotFound
+	}
+	// Expired items linger until DynamoDB gets round to deleting them
+	if expires := numberAttr(item, AttrExpires); expires > 0 && expires <= time.Now().Unix() {
+		return errNotFound
+	}
+
+	if err := nSessions.DecodeValues(session.Name(), stringAttr(item, AttrData), &session.Values,
+		d.Codecs...); err != nil {
+		return err
+	}
+	nSessions.SetMeta(session, nSessions.Meta{
+		Created:    timeAttr(item, AttrCreated),
+		LastAccess: timeAttr(item, AttrLastAccess),
+		IP:         stringAttr(item, AttrIP),
+		UserAgent:  stringAttr(item, AttrUserAgent),
+	})
+	return nil
+}
+
+func (d *nativeStore) save(ctx context.Context, session *gSessions.Session, isNew bool) error {
+	if !nSessions.ValidID(session.ID) {
+		return nSessions.ErrInvalidId
+	}
+
+	var modified time.Time
+	if val, ok := session.Values["modified"]; ok {
+		modified, ok = val.(time.Time)
+		if !ok {
+			return nSessions.ErrInvalidModified
+		}
+	} else {
+		modified = time.Now()
+	}
+
+	encoded, err := nSessions.EncodeValues(session.Name(),
+		nSessions.StripMeta(session.Values), d.compressor, d.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	meta := nSessions.GetMeta(session)
+	item := map[string]types.AttributeValue{
+		AttrID:         &types.AttributeValueMemberS{Value: session.ID},
+		AttrData:       &types.AttributeValueMemberS{Value: encoded},
+		AttrModified:   number(modified.Unix()),
+		AttrCreated:    number(meta.Created.Unix()),
+		AttrLastAccess: number(meta.LastAccess.Unix()),
+		AttrIP:         &types.AttributeValueMemberS{Value: meta.IP},
+		AttrUserAgent:  &types.AttributeValueMemberS{Value: meta.UserAgent},
+	}
+	if session.Options.MaxAge > 0 {
+		item[AttrExpires] = number(time.Now().Add(time.Duration(session.Options.MaxAge) * time.Second).Unix())
+	}
+
+	condition := "attribute_exists(#id)"
+	if isNew {
+		condition = "attribute_not_exists(#id)"
+	}
+	_, err = d.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(d.table),
+		Item:                     item,
+		ConditionExpression:      aws.String(condition),
+		ExpressionAttributeNames: map[string]string{"#id": AttrID},
+	})
+	return err
+}
+
+func (d *nativeStore) delete(ctx context.Context, session *gSessions.Session) error {
+	if !nSessions.ValidID(session.ID) {
+		return nSessions.ErrInvalidId
+	}
+	_, err := d.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(d.table),
+		Key:       key(session.ID),
+	})
+	return err
+}
+
+func key(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{AttrID: &types.AttributeValueMemberS{Value: id}}
+}
+
+func number(n int64) types.AttributeValue {
+	return &types.AttributeValueMemberN{Value: strconv.FormatInt(n, 10)}
+}
+
+func stringAttr(item map[string]types.AttributeValue, name string) string {
+	if v, ok := item[name].(*types.AttributeValueMemberS); ok {
+		return v.Value
+	}
+	return ""
+}
+
+func numberAttr(item map[string]types.AttributeValue, name string) int64 {
+	if v, ok := item[name].(*types.AttributeValueMemberN); ok {
+		n, _ := strconv.ParseInt(v.Value, 10, 64)
+		return n
+	}
+	return 0
+}
+
+func timeAttr(item map[string]types.AttributeValue, name string) time.Time {
+	if n := numberAttr(item, name); n > 0 {
+		return time.Unix(n, 0)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,78 @@
+package dynamostore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// TableClient is the subset of *dynamodb.Client used to create tables.
+type TableClient interface {
+	dynamodb.DescribeTableAPIClient
+	CreateTable(ctx context.Context, params *dynamodb.CreateTableInput, optFns ...func(*dynamodb.Options)) (*dynamodb.CreateTableOutput, error)
+	DescribeTimeToLive(ctx context.Context, params *dynamodb.DescribeTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error)
+	UpdateTimeToLive(ctx context.Context, params *dynamodb.UpdateTimeToLiveInput, optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error)
+}
+
+// CreateTable creates a table for NewWithClient, billed per request, if it
+// does not exist yet, waits up to maxWait for it to become active and
+// enables expiry of sessions on it with EnableTTL.
+func CreateTable(ctx context.Context, client TableClient, table string, maxWait time.Duration) error {
+	_, err := client.CreateTable(ctx, &dynamodb.CreateTableInput{
+		TableName: aws.String(table),
+		AttributeDefinitions: []types.AttributeDefinition{{
+			AttributeName: aws.String(AttrID),
+			AttributeType: types.ScalarAttributeTypeS,
+		}},
+		KeySchema: []types.KeySchemaElement{{
+			AttributeName: aws.String(AttrID),
+			KeyType:       types.KeyTypeHash,
+		}},
+		BillingMode: types.BillingModePayPerRequest,
+	})
+	var inUse *types.ResourceInUseException
+	if err != nil && !errors.As(err, &inUse) {
+		return err
+	}
+
+	waiter := dynamodb.NewTableExistsWaiter(client)
+	if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table)}, maxWait); err != nil {
+		return err
+	}
+	return EnableTTL(ctx, client, table)
+}
+
+// EnableTTL makes DynamoDB delete expired sessions from table, using the
+// AttrExpires attribute. It does nothing if TTL is already enabled on that
+// attribute, and fails if it is enabled on another, as a table has only
+// one TTL attribute.
+func EnableTTL(ctx context.Context, client TableClient, table string) error {
+	out, err := client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(table)})
+	if err != nil {
+		return err
+	}
+	if ttl := out.TimeToLiveDescription; ttl != nil && ttl.AttributeName != nil {
+		switch ttl.TimeToLiveStatus {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			if *ttl.AttributeName == AttrExpires {
+				return nil
+			}
+			return fmt.Errorf("dynamostore: TTL of table %s is enabled on attribute %s, not %s",
+				table, *ttl.AttributeName, AttrExpires)
+		}
+	}
+
+	_, err = client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(AttrExpires),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	return err
+}
@@ -1,10 +1,173 @@
 package sessions
 
 import (
+	"context"
 	"errors"
+	"io"
+	"net"
+
+	"github.com/gorilla/securecookie"
 )
 
 var (
 	ErrInvalidId       = errors.New("session: invalid session id")
-	ErrInvalidModified = errors.New("mongostore: invalid modified value")
+	ErrInvalidModified = errors.New("session: invalid modified value")
+	ErrNoSession       = errors.New("session: no session in request context")
+	ErrNoToken         = errors.New("session: no token in request")
+
+	// ErrNotFound means no session is stored under the requested ID.
+	ErrNotFound = errors.New("session: not found")
+	// ErrTampered means a session value failed verification or could not be
+	// decoded, e.g. because it was modified or the keys have changed.
+	ErrTampered = errors.New("session: value tampered with or undecodable")
+	// ErrExpired means a session value was signed too long ago.
+	ErrExpired = errors.New("session: value expired")
+	// ErrBackendUnavailable means the store could not reach its backend.
+	ErrBackendUnavailable = errors.New("session: backend unavailable")
+	// ErrTooLarge means a session is too large to be stored.
+	ErrTooLarge = errors.New("session: too large")
+	// ErrRevoked means a session was loaded whose ID has been revoked.
+	ErrRevoked = errors.New("session: revoked")
+	// ErrConflict means a session was not saved because it was modified or
+	// created by another request since it was loaded.
+	ErrConflict = errors.New("session: modified concurrently")
 )
+
+// Error is returned by the bundled stores. It records the store and
+// operation that failed, and the kind of failure as one of the errors above
+// so that errors.Is works on it, alongside the underlying error.
+type Error struct {
+	Store string
+	Op    string
+	Kind  error
+	Err   error
+
+	// logged is set once the store has logged the error
+	logged bool
+}
+
+func (e *Error) Error() string {
+	msg := e.Store + " " + e.Op + ": "
+	switch {
+	case e.Err == nil:
+		return msg + e.Kind.Error()
+	case e.Kind == nil:
+		return msg + e.Err.Error()
+	}
+	return msg + e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the kind and the underlying error.
+func (e *Error) Unwrap() []error {
+	errs := make([]error, 0, 2)
+	if e.Kind != nil {
+		errs = append(errs, e.Kind)
+	}
+	if e.Err != nil {
+		errs = append(errs, e.Err)
+	}
+	return errs
+}
+
+// NewError returns an *Error of the given kind for a failed operation of store.
+func NewError(store, op string, kind, err error) error {
+	return &Error{Store: store, Op: op, Kind: kind, Err: err}
+}
+
+// WrapError returns err as an *Error of the kind it is recognised as, or
+// with no kind if it is not recognised. Stores map their backend's own "not
+// found" errors with NewError before falling back to WrapError.
+func WrapError(store, op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return err
+	}
+	return NewError(store, op, errorKind(err), err)
+}
+
+func errorKind(err error) error {
+	for _, kind := range []error{ErrInvalidId, ErrInvalidModified, ErrNotFound, ErrTampered,
+		ErrExpired, ErrBackendUnavailable, ErrTooLarge, ErrRevoked,
+		ErrConflict} {
+		if errors.Is(err, kind) {
+			return nil
+		}
+	}
+
+	var multi securecookie.MultiError
+	if errors.As(err, &multi) {
+		// The first error other than a failed MAC is the most telling
+		for _, e := range multi {
+			if e != nil && e != securecookie.ErrMacInvalid {
+				return errorKind(e)
+			}
+		}
+		if len(multi) > 0 {
+			return errorKind(multi[0])
+		}
+	}
+
+	var cookieErr securecookie.Error
+	if errors.As(err, &cookieErr) {
+		switch {
+		case cookieErr.IsDecode() && cookieErr.Error() == "securecookie: expired timestamp":
+			return ErrExpired
+		case cookieErr.IsDecode():
+			return ErrTampered
+		case cookieErr.IsUsage() && cookieErr.Error() == "securecookie: the value is too long":
+			return ErrTooLarge
+		}
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return ErrBackendUnavailable
+	}
+	return nil
+}
+
+// Error classes returned by ErrorClass.
+const (
+	ClassInvalidID   = "invalid_id"
+	ClassNotFound    = "not_found"
+	ClassDecode      = "decode"
+	ClassExpired     = "expired"
+	ClassUnavailable = "unavailable"
+	ClassTooLarge    = "too_large"
+	ClassRevoked     = "revoked"
+	ClassConflict    = "conflict"
+	ClassBackend     = "backend"
+)
+
+// ErrorClass returns a short, stable name for the kind of failure err
+// represents, for use in logs and metrics. It returns "" for a nil error.
+func ErrorClass(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrInvalidId) {
+		return ClassInvalidID
+	}
+	switch kind := errorKind(err); {
+	case errors.Is(err, ErrNotFound):
+		return ClassNotFound
+	case errors.Is(err, ErrRevoked):
+		return ClassRevoked
+	case errors.Is(err, ErrConflict):
+		return ClassConflict
+	case errors.Is(err, ErrExpired) || kind == ErrExpired:
+		return ClassExpired
+	case errors.Is(err, ErrTampered) || kind == ErrTampered:
+		return ClassDecode
+	case errors.Is(err, ErrBackendUnavailable) || kind == ErrBackendUnavailable:
+		return ClassUnavailable
+	case errors.Is(err, ErrTooLarge) || kind == ErrTooLarge:
+		return ClassTooLarge
+	}
+	return ClassBackend
+}
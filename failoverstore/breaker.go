@@ -0,0 +1,51 @@
+package failoverstore
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker is a circuit breaker. It opens after threshold consecutive
+// failures, and lets one trial operation through each cooldown until an
+// operation succeeds.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func (b *breaker) allow(threshold int, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < threshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	// Half open: this caller makes the trial, others wait for its outcome
+	b.openUntil = now.Add(cooldown)
+	return true
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	b.failures = 0
+	b.mu.Unlock()
+}
+
+func (b *breaker) failure(threshold int, cooldown time.Duration) {
+	b.mu.Lock()
+	b.failures++
+	if b.failures >= threshold {
+		b.openUntil = time.Now().Add(cooldown)
+	}
+	b.mu.Unlock()
+}
+
+func (b *breaker) closed(threshold int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures < threshold
+}
@@ -0,0 +1,332 @@
+// Package failoverstore replicates sessions across stores and fails over
+// between them, so that an outage of one backend does not log users out.
+package failoverstore
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/goincremental/negroni-sessions/internal/writer"
+	gSessions "github.com/gorilla/sessions"
+)
+
+const (
+	// DefaultThreshold is the number of consecutive failures after which a
+	// store is skipped.
+	DefaultThreshold = 5
+	// DefaultCooldown is how long a failing store is skipped before it is
+	// tried again.
+	DefaultCooldown = 30 * time.Second
+
+	// defaultMaxAge is how long sessions are assumed to live, in seconds,
+	// unless set through Options.
+	defaultMaxAge = 86400 * 30
+
+	// unavailableKey marks a session that could not be loaded because no
+	// store was available. It is never stored.
+	unavailableKey = "_unavailable"
+)
+
+// Store is a session store failing over between several stores.
+type Store interface {
+	nSessions.Store
+	// Breaker sets the number of consecutive failures after which a store
+	// is skipped, and how long it is skipped before it is tried again.
+	Breaker(threshold int, cooldown time.Duration)
+	// Logger sets the logger that failures of individual stores are
+	// reported to. Nothing is logged by the store until it is set.
+	Logger(*slog.Logger)
+	// Healthy reports, for each store in order, whether it is in use.
+	Healthy() []bool
+	// Run checks the health of every store with check each interval until
+	// ctx is done, skipping stores that fail and restoring those that
+	// recover without waiting for user requests to try them.
+	Run(ctx context.Context, check func(context.Context, nSessions.Store) error, interval time.Duration)
+}
+
+// New returns a store that saves sessions to primary and each of
+// secondaries, and loads them from the first of those that is available
+// and holds the session.
+//
+// The stores must use the same cookie keys, so that the cookie written by
+// any of them is read by all; IDs are assigned by the failover store and
+// are the same in each. Stores that save conditionally, such as
+// memcachestore, reject updates to sessions they missed while they were
+// down, so stores that save unconditionally make better replicas.
+//
+// A store that is skipped or fails while a session is saved or deleted
+// misses the write, and its copy of the session is not read again until a
+// later save reaches it. A missed delete is made once the store is reached
+// again, rather than the session being restored from it. Missed writes are
+// tracked in memory, so they are forgotten when the process restarts and
+// are not known to other processes sharing the stores.
+//
+// A store whose backend fails repeatedly is skipped until it recovers. If
+// no store can load a session, the session is treated as new but is not
+// saved, so its cookie is kept for when the backends return; saving it
+// fails with ErrBackendUnavailable.
+func New(primary nSessions.Store, secondaries ...nSessions.Store) Store {
+	stores := append([]nSessions.Store{primary}, secondaries...)
+	return &failoverStore{
+		stores:    stores,
+		breakers:  make([]breaker, len(stores)),
+		missed:    make([]missed, len(stores)),
+		maxAge:    defaultMaxAge,
+		threshold: DefaultThreshold,
+		cooldown:  DefaultCooldown,
+		ids:       nSessions.DefaultIDGenerator,
+	}
+}
+
+type failoverStore struct {
+	stores    []nSessions.Store
+	breakers  []breaker
+	missed    []missed
+	maxAge    int
+	threshold int
+	cooldown  time.Duration
+	ids       nSessions.IDGenerator
+	logger    *slog.Logger
+}
+
+func (f *failoverStore) Options(options nSessions.Options) {
+	if options.MaxAge > 0 {
+		f.maxAge = options.MaxAge
+	}
+	for _, store := range f.stores {
+		store.Options(options)
+	}
+}
+
+func (f *failoverStore) Breaker(threshold int, cooldown time.Duration) {
+	f.threshold = threshold
+	f.cooldown = cooldown
+}
+
+// IDGenerator sets the generator of new session IDs.
+func (f *failoverStore) IDGenerator(ids nSessions.IDGenerator) {
+	f.ids = ids
+}
+
+func (f *failoverStore) Logger(logger *slog.Logger) {
+	f.logger = logger
+}
+
+func (f *failoverStore) Healthy() []bool {
+	healthy := make([]bool, len(f.stores))
+	for i := range f.breakers {
+		healthy[i] = f.breakers[i].closed(f.threshold)
+	}
+	return healthy
+}
+
+func (f *failoverStore) Run(ctx context.Context, check func(context.Context, nSessions.Store) error,
+	interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		for i, store := range f.stores {
+			if err := check(ctx, store); err != nil {
+				f.breakers[i].failure(f.threshold, f.cooldown)
+			} else {
+				f.breakers[i].success()
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (f *failoverStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(f, name)
+}
+
+// Ping checks the backend of each store, succeeding if any of them is
+// reachable, as sessions are then still loaded and saved.
+func (f *failoverStore) Ping(ctx context.Context) error {
+	var firstErr error
+	for _, store := range f.stores {
+		err := nSessions.Ping(ctx, store)
+		switch {
+		case err == nil:
+			return nil
+		case firstErr == nil || errors.Is(firstErr, nSessions.ErrPingUnsupported):
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// New loads the session from the first available store that holds it.
+func (f *failoverStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	var first *gSessions.Session
+	var firstErr error
+	available := false
+	for i, store := range f.stores {
+		if !f.breakers[i].allow(f.threshold, f.cooldown) {
+			continue
+		}
+		session, err := store.New(r, name)
+		if f.record(r, i, "load", name, err) {
+			continue
+		}
+		available = true
+		if err == nil && session != nil && !session.IsNew {
+			if !f.stale(r, i, session) {
+				return session, nil
+			}
+			session = fresh(session)
+		}
+		if first == nil {
+			first, firstErr = session, err
+		}
+	}
+
+	if !available {
+		session := gSessions.NewSession(f, name)
+		session.Values[unavailableKey] = true
+		return session, nSessions.NewError("failoverstore", "load", nSessions.ErrBackendUnavailable, nil)
+	}
+	return first, firstErr
+}
+
+// Save saves the session to every available store, succeeding if any of
+// them does. Stores that fail to save it are skipped until they recover.
+func (f *failoverStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	if _, ok := session.Values[unavailableKey]; ok {
+		return nSessions.NewError("failoverstore", "save", nSessions.ErrBackendUnavailable, nil)
+	}
+	op := "save"
+	if session.Options != nil && session.Options.MaxAge < 0 {
+		op = "delete"
+	}
+	if session.ID == "" && op == "save" {
+		id, err := f.ids.NewID()
+		if err != nil {
+			return nSessions.WrapError("failoverstore", op, err)
+		}
+		session.ID = id
+	}
+
+	retention := time.Duration(f.maxAge) * time.Second
+	var saved *gSessions.Session
+	var lastErr error
+	for i, store := range f.stores {
+		if !f.breakers[i].allow(f.threshold, f.cooldown) {
+			f.miss(i, session, op, retention)
+			continue
+		}
+		// Stores update the session as they save it, e.g. clearing IsNew,
+		// so each is given its own copy
+		replica := copySession(session)
+		header := make(http.Header)
+		err := store.Save(r, writer.Headers(w, header), replica)
+		if op == "delete" && nSessions.ErrorClass(err) == nSessions.ClassNotFound {
+			err = nil
+		}
+		if err != nil {
+			// A store that rejects the write no longer holds the session,
+			// so it is skipped like one that is down
+			f.breakers[i].failure(f.threshold, f.cooldown)
+			f.miss(i, session, op, retention)
+			nSessions.LogError(f.logger, r, "failoverstore", op, session.Name(), err)
+			lastErr = err
+			continue
+		}
+		f.breakers[i].success()
+		f.missed[i].clear(key(session.Name(), session.ID))
+		// Every store sets the same cookie, which is only needed once
+		if saved == nil {
+			saved = replica
+			writer.Copy(w, header)
+		}
+	}
+	switch {
+	case saved != nil:
+		session.ID, session.IsNew, session.Values = saved.ID, saved.IsNew, saved.Values
+		return nil
+	case lastErr != nil:
+		return lastErr
+	}
+	return nSessions.NewError("failoverstore", op, nSessions.ErrBackendUnavailable, nil)
+}
+
+// miss records that store i missed a write of session.
+func (f *failoverStore) miss(i int, session *gSessions.Session, op string, retention time.Duration) {
+	if session.ID != "" {
+		f.missed[i].record(key(session.Name(), session.ID), op == "delete", retention)
+	}
+}
+
+// stale reports whether store i missed a write of session, which it loaded,
+// making the missed delete of a deleted session.
+func (f *failoverStore) stale(r *http.Request, i int, session *gSessions.Session) bool {
+	k := key(session.Name(), session.ID)
+	deleted, ok := f.missed[i].lookup(k)
+	if !ok {
+		return false
+	}
+	if deleted {
+		del := copySession(session)
+		del.Options.MaxAge = -1
+		err := f.stores[i].Save(r, writer.Discard, del)
+		if err == nil || nSessions.ErrorClass(err) == nSessions.ClassNotFound {
+			f.missed[i].clear(k)
+		}
+	}
+	return true
+}
+
+// fresh returns a new session in place of the stale session, keeping its
+// options.
+func fresh(session *gSessions.Session) *gSessions.Session {
+	c := gSessions.NewSession(session.Store(), session.Name())
+	c.IsNew = true
+	if session.Options != nil {
+		options := *session.Options
+		c.Options = &options
+	}
+	return c
+}
+
+// copySession returns a copy of session that can be changed without
+// changing session.
+func copySession(session *gSessions.Session) *gSessions.Session {
+	c := gSessions.NewSession(session.Store(), session.Name())
+	c.ID = session.ID
+	c.IsNew = session.IsNew
+	for key, value := range session.Values {
+		c.Values[key] = value
+	}
+	if session.Options != nil {
+		options := *session.Options
+		c.Options = &options
+	}
+	return c
+}
+
+// record updates the breaker of store i with the outcome of an operation,
+// reporting whether the store was unavailable.
+func (f *failoverStore) record(r *http.Request, i int, op, name string, err error) bool {
+	switch nSessions.ErrorClass(err) {
+	case nSessions.ClassUnavailable, nSessions.ClassBackend:
+		f.breakers[i].failure(f.threshold, f.cooldown)
+		nSessions.LogError(f.logger, r, "failoverstore", op, name, err)
+		return true
+	}
+	f.breakers[i].success()
+	return false
+}
+
+func key(name, id string) string {
+	return name + "\x00" + id
+}
@@ -0,0 +1,55 @@
+package failoverstore
+
+import (
+	"sync"
+	"time"
+)
+
+// missed records the sessions whose writes a store missed while it was
+// skipped or failing, so that its stale copies of them are not read. A
+// record is dropped once the store is written again, or after retention,
+// by when the stale copy has expired.
+type missed struct {
+	mu     sync.Mutex
+	writes map[string]miss
+	pruned time.Time
+}
+
+type miss struct {
+	deleted bool
+	at      time.Time
+}
+
+func (m *missed) record(key string, deleted bool, retention time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if m.writes == nil {
+		m.writes = make(map[string]miss)
+	}
+	m.writes[key] = miss{deleted: deleted, at: now}
+	if now.Sub(m.pruned) < time.Minute {
+		return
+	}
+	for k, w := range m.writes {
+		if now.Sub(w.at) > retention {
+			delete(m.writes, k)
+		}
+	}
+	m.pruned = now
+}
+
+// lookup reports whether the store missed a write of the session with the
+// given key, and if so whether that write deleted it.
+func (m *missed) lookup(key string) (deleted, ok bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w, ok := m.writes[key]
+	return w.deleted, ok
+}
+
+func (m *missed) clear(key string) {
+	m.mu.Lock()
+	delete(m.writes, key)
+	m.mu.Unlock()
+}
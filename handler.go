@@ -0,0 +1,110 @@
+package sessions
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// Handler is plain net/http middleware that maps a Session into the request
+// context like Sessions does, for use with routers and handler chains that
+// do not use negroni.ResponseWriter. The session is saved just before the
+// response headers are written.
+func Handler(name string, store Store) func(http.Handler) http.Handler {
+	return HandlerWithConfig(name, store, Config{})
+}
+
+// HandlerWithConfig is like Handler but takes additional configuration.
+func HandlerWithConfig(name string, store Store, config Config) func(http.Handler) http.Handler {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := newSession(name, w, r, store, &config, logger)
+			if !s.checkBinding(w, config.Binding) {
+				return
+			}
+
+			rw := &responseWriter{ResponseWriter: w}
+			rw.before = func() {
+				s.saveWritten(w)
+			}
+
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), sessionKey, s)))
+
+			// Nothing was written, so the headers can still be sent
+			if !rw.wroteHeader && !rw.hijacked {
+				rw.wroteHeader = true
+				rw.before()
+			}
+		})
+	}
+}
+
+// responseWriter calls before once, just before the response headers are
+// written.
+type responseWriter struct {
+	http.ResponseWriter
+	before      func()
+	wroteHeader bool
+	hijacked    bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	// Informational responses are followed by the real headers
+	if !w.wroteHeader && (code >= 200 || code == http.StatusSwitchingProtocols) {
+		w.wroteHeader = true
+		w.before()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, doing nothing if the underlying
+// ResponseWriter cannot flush.
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker. The session is not saved automatically
+// once the connection has been hijacked; use Save instead.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("sessions: ResponseWriter does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Push implements http.Pusher.
+func (w *responseWriter) Push(target string, opts *http.PushOptions) error {
+	if p, ok := w.ResponseWriter.(http.Pusher); ok {
+		return p.Push(target, opts)
+	}
+	return http.ErrNotSupported
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
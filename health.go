@@ -0,0 +1,116 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Pinger is implemented by stores that can check that their backend is
+// reachable.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// DefaultPingTimeout limits each check made by HealthHandler.
+var DefaultPingTimeout = 2 * time.Second
+
+// ErrPingUnsupported is returned by Ping for stores that cannot check their
+// backend, including stores wrapping only such stores.
+var ErrPingUnsupported = errors.New("session: ping not supported")
+
+// Ping checks the backend of store if it implements Pinger, and returns
+// ErrPingUnsupported otherwise. Stores wrapping other stores use it to
+// forward Ping.
+func Ping(ctx context.Context, store Store) error {
+	pinger, ok := store.(Pinger)
+	if !ok {
+		return ErrPingUnsupported
+	}
+	return pinger.Ping(ctx)
+}
+
+// Health is the report written by HealthHandler.
+type Health struct {
+	// Status is "ok" if every store responded, and "unavailable" otherwise.
+	Status string                 `json:"status"`
+	Stores map[string]StoreHealth `json:"stores"`
+}
+
+// StoreHealth is the health of a single store.
+type StoreHealth struct {
+	// Status is "ok", "unavailable", or "unknown" for stores that do not
+	// implement Pinger.
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// HealthHandler returns a handler that pings the given stores, keyed by
+// the names to report them under, and writes their health as JSON, e.g.
+// for a readiness probe. It responds with 503 Service Unavailable if any
+// store fails to respond within DefaultPingTimeout. Stores that do not
+// implement Pinger, or whose Ping returns ErrPingUnsupported, are reported
+// as unknown and do not affect the status.
+func HealthHandler(stores map[string]Store) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), DefaultPingTimeout)
+		defer cancel()
+
+		health := Health{Status: "ok", Stores: make(map[string]StoreHealth, len(stores))}
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		for name, store := range stores {
+			pinger, ok := store.(Pinger)
+			if !ok {
+				mu.Lock()
+				health.Stores[name] = StoreHealth{Status: "unknown"}
+				mu.Unlock()
+				continue
+			}
+			wg.Add(1)
+			go func(name string, pinger Pinger) {
+				defer wg.Done()
+				start := time.Now()
+				err := ping(ctx, pinger)
+				h := StoreHealth{Status: "ok", LatencyMS: float64(time.Since(start)) / float64(time.Millisecond)}
+				unsupported := errors.Is(err, ErrPingUnsupported)
+				switch {
+				case unsupported:
+					h = StoreHealth{Status: "unknown"}
+				case err != nil:
+					h.Status, h.Error = "unavailable", err.Error()
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				health.Stores[name] = h
+				if err != nil && !unsupported {
+					health.Status = "unavailable"
+				}
+			}(name, pinger)
+		}
+		wg.Wait()
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if health.Status != "ok" {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(health)
+	})
+}
+
+// ping calls p, giving up when ctx is done even if p does not.
+func ping(ctx context.Context, p Pinger) error {
+	done := make(chan error, 1)
+	go func() { done <- p.Ping(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
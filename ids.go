@@ -0,0 +1,53 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// MaxIDLength is the longest session ID the bundled stores accept.
+const MaxIDLength = 128
+
+// IDGenerator generates session IDs for stores that keep session data on
+// the server. IDs must be unguessable, since anyone presenting one is given
+// the session.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// IDGeneratorSetter is implemented by stores whose session IDs can be
+// generated by a custom IDGenerator.
+type IDGeneratorSetter interface {
+	IDGenerator(IDGenerator)
+}
+
+// RandomIDGenerator generates IDs from 256 bits read from crypto/rand,
+// encoded as unpadded URL-safe base64.
+type RandomIDGenerator struct{}
+
+// NewID implements IDGenerator.
+func (RandomIDGenerator) NewID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// DefaultIDGenerator is used by the bundled stores unless they are given
+// another IDGenerator.
+var DefaultIDGenerator IDGenerator = RandomIDGenerator{}
+
+// ValidID reports whether id is acceptable as a session ID: it must be
+// non-empty, no longer than MaxIDLength and made of printable ASCII.
+func ValidID(id string) bool {
+	if id == "" || len(id) > MaxIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,53 @@
+// Package hashring maps keys onto nodes by consistent hashing, so that
+// adding or removing a node only moves the keys of that node.
+package hashring
+
+import (
+	"hash/crc32"
+	"sort"
+	"strconv"
+)
+
+// DefaultReplicas is the number of points each node is given on the ring
+// when New is passed no more than zero.
+const DefaultReplicas = 160
+
+// Ring is a consistent hash ring. It is safe for concurrent use, as it is
+// not modified after New.
+type Ring struct {
+	points []uint32
+	nodes  map[uint32]string
+}
+
+// New returns a ring of the given nodes, each placed at replicas points.
+func New(nodes []string, replicas int) *Ring {
+	if replicas <= 0 {
+		replicas = DefaultReplicas
+	}
+	r := &Ring{nodes: make(map[uint32]string, len(nodes)*replicas)}
+	for _, node := range nodes {
+		for i := 0; i < replicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(node + "#" + strconv.Itoa(i)))
+			if _, taken := r.nodes[point]; taken {
+				continue
+			}
+			r.nodes[point] = node
+			r.points = append(r.points, point)
+		}
+	}
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r
+}
+
+// Get returns the node that key maps to, or "" if the ring is empty.
+func (r *Ring) Get(key string) string {
+	if len(r.points) == 0 {
+		return ""
+	}
+	hash := crc32.ChecksumIEEE([]byte(key))
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.nodes[r.points[i]]
+}
@@ -0,0 +1,38 @@
+// Package writer provides the response writers that stores wrapping other
+// stores hand to them, to capture or drop what they write.
+package writer
+
+import "net/http"
+
+// Headers returns a writer that passes writes on to w, but keeps headers
+// in header instead of those of w, so that the headers a store sets can be
+// inspected or dropped before they reach the response.
+func Headers(w http.ResponseWriter, header http.Header) http.ResponseWriter {
+	return headerWriter{w, header}
+}
+
+// Copy adds the values in header to the headers of w.
+func Copy(w http.ResponseWriter, header http.Header) {
+	for key, values := range header {
+		w.Header()[key] = append(w.Header()[key], values...)
+	}
+}
+
+// Discard is a writer that drops everything written to it, for saves made
+// outside any request's response.
+var Discard http.ResponseWriter = discard{}
+
+type headerWriter struct {
+	http.ResponseWriter
+	header http.Header
+}
+
+func (h headerWriter) Header() http.Header {
+	return h.header
+}
+
+type discard struct{}
+
+func (discard) Header() http.Header         { return http.Header{} }
+func (discard) Write(p []byte) (int, error) { return len(p), nil }
+func (discard) WriteHeader(int)             {}
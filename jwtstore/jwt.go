@@ -0,0 +1,259 @@
+package jwtstore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+)
+
+// Signing algorithms.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// Key signs or verifies tokens.
+type Key struct {
+	// ID is sent as the kid header of tokens signed with the key, and
+	// selects the key that verifies a token.
+	ID string
+	// Algorithm is HS256, RS256 or EdDSA.
+	Algorithm string
+	// Secret is the HMAC key of an HS256 key.
+	Secret []byte
+	// PrivateKey signs RS256 (*rsa.PrivateKey) or EdDSA
+	// (ed25519.PrivateKey) tokens. It is nil for keys that only verify.
+	PrivateKey crypto.Signer
+	// PublicKey verifies RS256 (*rsa.PublicKey) or EdDSA
+	// (ed25519.PublicKey) tokens. It defaults to the public half of
+	// PrivateKey.
+	PublicKey crypto.PublicKey
+}
+
+func (k *Key) check() error {
+	if k.PublicKey == nil && k.PrivateKey != nil {
+		k.PublicKey = k.PrivateKey.Public()
+	}
+	switch k.Algorithm {
+	case HS256:
+		if len(k.Secret) == 0 {
+			return fmt.Errorf("jwtstore: key %q: HS256 requires a secret", k.ID)
+		}
+		return nil
+	case RS256:
+		if _, ok := k.PublicKey.(*rsa.PublicKey); ok {
+			return nil
+		}
+	case EdDSA:
+		if _, ok := k.PublicKey.(ed25519.PublicKey); ok {
+			return nil
+		}
+	default:
+		return fmt.Errorf("jwtstore: key %q: unsupported algorithm %q", k.ID, k.Algorithm)
+	}
+	return fmt.Errorf("jwtstore: key %q: wrong key type for %s", k.ID, k.Algorithm)
+}
+
+func (k *Key) canSign() bool {
+	return k.Algorithm == HS256 || k.PrivateKey != nil
+}
+
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case RS256:
+		digest := sha256.Sum256(input)
+		return k.PrivateKey.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	return k.PrivateKey.Sign(rand.Reader, input, crypto.Hash(0))
+}
+
+func (k *Key) verify(input, signature []byte) bool {
+	switch k.Algorithm {
+	case HS256:
+		mac := hmac.New(sha256.New, k.Secret)
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case RS256:
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k.PublicKey.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	case EdDSA:
+		return ed25519.Verify(k.PublicKey.(ed25519.PublicKey), input, signature)
+	}
+	return false
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc,omitempty"`
+	Typ string `json:"typ,omitempty"`
+	Cty string `json:"cty,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+var (
+	b64 = base64.RawURLEncoding
+
+	errMalformed = fmt.Errorf("%w: malformed token", nSessions.ErrTampered)
+	errSignature = fmt.Errorf("%w: invalid token signature", nSessions.ErrTampered)
+	errNoKey     = fmt.Errorf("%w: no key for token", nSessions.ErrTampered)
+)
+
+// signToken returns claims as a compact JWS signed with key.
+func signToken(key *Key, claims map[string]interface{}) (string, error) {
+	h, err := json.Marshal(header{Alg: key.Algorithm, Typ: "JWT", Kid: key.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	input := b64.EncodeToString(h) + "." + b64.EncodeToString(payload)
+	signature, err := key.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + b64.EncodeToString(signature), nil
+}
+
+// verifyToken checks the signature of a compact JWS against keys and
+// returns its claims.
+func verifyToken(keys []Key, token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, err
+	}
+	signature, err := b64.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformed
+	}
+
+	input := []byte(parts[0] + "." + parts[1])
+	verified, found := false, false
+	for i := range keys {
+		key := &keys[i]
+		// The algorithm must match the key, so a token cannot pick a
+		// weaker check than the key was meant for
+		if key.Algorithm != h.Alg || (h.Kid != "" && key.ID != h.Kid) {
+			continue
+		}
+		found = true
+		if key.verify(input, signature) {
+			verified = true
+			break
+		}
+	}
+	switch {
+	case !found:
+		return nil, errNoKey
+	case !verified:
+		return nil, errSignature
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := b64.DecodeString(segment)
+	if err != nil {
+		return errMalformed
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	if err := d.Decode(v); err != nil {
+		return errMalformed
+	}
+	return nil
+}
+
+// encryptToken wraps a signed token in a compact JWE using direct
+// encryption with AES-256-GCM.
+func encryptToken(key []byte, token string) (string, error) {
+	h, err := json.Marshal(header{Alg: "dir", Enc: "A256GCM", Cty: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	protected := b64.EncodeToString(h)
+	sealed := aead.Seal(nil, iv, []byte(token), []byte(protected))
+	ciphertext, tag := sealed[:len(sealed)-aead.Overhead()], sealed[len(sealed)-aead.Overhead():]
+	return protected + ".." + b64.EncodeToString(iv) + "." + b64.EncodeToString(ciphertext) + "." +
+		b64.EncodeToString(tag), nil
+}
+
+// decryptToken opens a compact JWE written by encryptToken with the first
+// of keys that can.
+func decryptToken(keys [][]byte, token string) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return "", errMalformed
+	}
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return "", err
+	}
+	if h.Alg != "dir" || h.Enc != "A256GCM" {
+		return "", errMalformed
+	}
+	iv, err1 := b64.DecodeString(parts[2])
+	ciphertext, err2 := b64.DecodeString(parts[3])
+	tag, err3 := b64.DecodeString(parts[4])
+	if err := errors.Join(err1, err2, err3); err != nil {
+		return "", errMalformed
+	}
+
+	for _, key := range keys {
+		aead, err := newGCM(key)
+		if err != nil || len(iv) != aead.NonceSize() {
+			continue
+		}
+		plain, err := aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+		if err == nil {
+			return string(plain), nil
+		}
+	}
+	return "", errSignature
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("jwtstore: encryption key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
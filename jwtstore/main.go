@@ -0,0 +1,245 @@
+package jwtstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	gSessions "github.com/gorilla/sessions"
+)
+
+// Leeway is the clock skew tolerated when checking the exp and nbf claims.
+var Leeway = 30 * time.Second
+
+// Registered claims set by the store. Session values under these keys are
+// not saved.
+const (
+	claimExpires   = "exp"
+	claimIssuedAt  = "iat"
+	claimNotBefore = "nbf"
+	claimID        = "jti"
+	claimMeta      = "_meta"
+)
+
+// Store is a session store that keeps sessions in signed JSON Web Tokens,
+// so no session data is kept on the server.
+//
+// Session values are stored as claims, so keys must be strings and values
+// must be encodable as JSON. Values come back as their JSON equivalent: a
+// number is loaded as a float64 and a struct as a map[string]interface{}.
+// Tokens are signed but not encrypted unless encryption keys are set, so
+// clients can read the values.
+//
+// A token is valid until it expires, as set by MaxAge; deleting a session
+// only removes the token from the client. The jti claim is the session ID.
+type Store interface {
+	nSessions.Store
+	nSessions.LoggerSetter
+	// Encryption sets 32 byte AES-256 keys with which tokens are encrypted
+	// as JWE. The first key encrypts and all of them decrypt, so keys can be
+	// rotated by prepending the new one. No keys turns encryption off.
+	Encryption(keys ...[]byte)
+	// Token sets where the token is read from and written to. It defaults
+	// to a cookie named after the session; nSessions.NewHeaderToken keeps
+	// it in a header instead.
+	Token(nSessions.TokenGetSetter)
+}
+
+// New returns a new JWT store. Tokens are signed with the first key that
+// has a secret or private key, and verified with whichever key has the
+// algorithm and ID given in their header. Keys are rotated by prepending a
+// new signing key and keeping the old ones until their tokens have expired.
+func New(keys ...Key) (Store, error) {
+	j := &jwtStore{
+		keys:  make([]Key, len(keys)),
+		token: nSessions.NewCookieToken(),
+		options: &gSessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		ids: nSessions.DefaultIDGenerator,
+	}
+	copy(j.keys, keys)
+	for i := range j.keys {
+		if err := j.keys[i].check(); err != nil {
+			return nil, err
+		}
+		if j.signer == nil && j.keys[i].canSign() {
+			j.signer = &j.keys[i]
+		}
+	}
+	if j.signer == nil {
+		return nil, errors.New("jwtstore: no key can sign tokens")
+	}
+	return j, nil
+}
+
+type jwtStore struct {
+	keys       []Key
+	signer     *Key
+	encryption [][]byte
+	token      nSessions.TokenGetSetter
+	options    *gSessions.Options
+	logger     *slog.Logger
+	ids        nSessions.IDGenerator
+}
+
+func (j *jwtStore) Options(options nSessions.Options) {
+	j.options = &gSessions.Options{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HTTPOnly,
+	}
+}
+
+func (j *jwtStore) Encryption(keys ...[]byte) {
+	j.encryption = keys
+}
+
+func (j *jwtStore) Token(token nSessions.TokenGetSetter) {
+	j.token = token
+}
+
+func (j *jwtStore) Logger(logger *slog.Logger) {
+	j.logger = logger
+}
+
+// IDGenerator sets the generator of the jti claim of new sessions.
+func (j *jwtStore) IDGenerator(ids nSessions.IDGenerator) {
+	j.ids = ids
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (j *jwtStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(j, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (j *jwtStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	session := gSessions.NewSession(j, name)
+	options := *j.options
+	session.Options = &options
+	session.IsNew = true
+
+	token, err := j.token.GetToken(r, name)
+	if err != nil || token == "" {
+		return session, nil
+	}
+	if err = j.load(session, token); err != nil {
+		session.Values = make(map[interface{}]interface{})
+		session.ID = ""
+		err = nSessions.WrapError("jwtstore", "load", err)
+		nSessions.LogError(j.logger, r, "jwtstore", "load", name, err)
+		return session, err
+	}
+	session.IsNew = false
+	return session, nil
+}
+
+// Save adds a single session to the response.
+func (j *jwtStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		j.token.SetToken(w, session.Name(), "", session.Options)
+		return nil
+	}
+	token, err := j.encode(session)
+	if err != nil {
+		err = nSessions.WrapError("jwtstore", "save", err)
+		nSessions.LogError(j.logger, r, "jwtstore", "save", session.Name(), err)
+		return err
+	}
+	j.token.SetToken(w, session.Name(), token, session.Options)
+	return nil
+}
+
+func (j *jwtStore) load(session *gSessions.Session, token string) error {
+	if len(j.encryption) > 0 {
+		var err error
+		if token, err = decryptToken(j.encryption, token); err != nil {
+			return err
+		}
+	}
+	claims, err := verifyToken(j.keys, token)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if exp, ok := claims[claimExpires].(float64); ok && now.After(unix(exp).Add(Leeway)) {
+		return nSessions.NewError("jwtstore", "load", nSessions.ErrExpired, nil)
+	}
+	if nbf, ok := claims[claimNotBefore].(float64); ok && now.Add(Leeway).Before(unix(nbf)) {
+		return fmt.Errorf("%w: token not valid yet", nSessions.ErrTampered)
+	}
+
+	for k, v := range claims {
+		switch k {
+		case claimExpires, claimIssuedAt, claimNotBefore:
+		case claimID:
+			session.ID, _ = v.(string)
+		case claimMeta:
+			var m nSessions.Meta
+			if data, err := json.Marshal(v); err == nil && json.Unmarshal(data, &m) == nil {
+				nSessions.SetMeta(session, m)
+			}
+		default:
+			session.Values[k] = v
+		}
+	}
+	return nil
+}
+
+func (j *jwtStore) encode(session *gSessions.Session) (string, error) {
+	if session.ID == "" {
+		id, err := j.ids.NewID()
+		if err != nil {
+			return "", err
+		}
+		session.ID = id
+	}
+
+	values := nSessions.StripMeta(session.Values)
+	claims := make(map[string]interface{}, len(values)+5)
+	for k, v := range values {
+		key, ok := k.(string)
+		if !ok {
+			return "", fmt.Errorf("jwtstore: session value key %v is not a string", k)
+		}
+		claims[key] = v
+	}
+
+	now := time.Now()
+	claims[claimIssuedAt] = now.Unix()
+	claims[claimNotBefore] = now.Unix()
+	if session.Options.MaxAge > 0 {
+		claims[claimExpires] = now.Add(time.Duration(session.Options.MaxAge) * time.Second).Unix()
+	} else {
+		delete(claims, claimExpires)
+	}
+	claims[claimID] = session.ID
+	if m := nSessions.GetMeta(session); m != (nSessions.Meta{}) {
+		claims[claimMeta] = m
+	} else {
+		delete(claims, claimMeta)
+	}
+
+	token, err := signToken(j.signer, claims)
+	if err != nil {
+		return "", err
+	}
+	if len(j.encryption) > 0 {
+		return encryptToken(j.encryption[0], token)
+	}
+	return token, nil
+}
+
+func unix(seconds float64) time.Time {
+	return time.Unix(int64(seconds), 0)
+}
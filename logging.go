@@ -0,0 +1,61 @@
+package sessions
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+)
+
+// RequestIDHeader is the request header whose value is logged as request_id.
+var RequestIDHeader = "X-Request-Id"
+
+// LoggerSetter is implemented by stores that can report their failures to a
+// structured logger.
+type LoggerSetter interface {
+	// Logger sets the logger that load, save and delete failures are
+	// reported to. Nothing is logged by the store until it is set.
+	Logger(*slog.Logger)
+}
+
+// LogError writes a structured record of a failed session operation to
+// logger, for stores to report their failures. Decode failures, expired
+// values and revoked sessions are not logged, as they are usually caused by
+// clients and are left to the caller: the middleware logs them or passes
+// them to Config.OnDecodeError. Errors logged are marked, so that the
+// middleware does not log them again.
+func LogError(logger *slog.Logger, r *http.Request, store, op, name string, err error) {
+	switch ErrorClass(err) {
+	case ClassDecode, ClassExpired, ClassRevoked:
+		return
+	}
+	if logger == nil || err == nil {
+		return
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		e.logged = true
+	}
+	logRecord(logger, r, store, op, name, err)
+}
+
+// logRecord writes a structured record of a failed session operation.
+// Missing sessions are logged at debug level, failures usually caused by
+// clients as warnings and everything else as errors.
+func logRecord(logger *slog.Logger, r *http.Request, store, op, name string, err error) {
+	class := ErrorClass(err)
+	level := slog.LevelError
+	switch class {
+	case ClassNotFound:
+		level = slog.LevelDebug
+	case ClassDecode, ClassExpired, ClassRevoked:
+		level = slog.LevelWarn
+	}
+	logger.LogAttrs(r.Context(), level, "session "+op+" failed",
+		slog.String("session", name),
+		slog.String("store", store),
+		slog.String("op", op),
+		slog.String("error_class", class),
+		slog.String("request_id", r.Header.Get(RequestIDHeader)),
+		slog.Any("error", err),
+	)
+}
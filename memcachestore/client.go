@@ -0,0 +1,209 @@
+package memcachestore
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goincremental/negroni-sessions/internal/hashring"
+)
+
+// Results of storage commands that are not failures of the connection.
+var (
+	errCacheMiss = errors.New("memcache: cache miss")
+	errNotStored = errors.New("memcache: item not stored")
+	errCASExists = errors.New("memcache: item modified since it was read")
+	errTooLarge  = errors.New("memcache: item too large")
+)
+
+type item struct {
+	value []byte
+	cas   uint64
+}
+
+// client speaks the memcached text protocol to a set of servers, picking
+// the server for a key from a consistent hash ring.
+type client struct {
+	ring    *hashring.Ring
+	pools   map[string]*pool
+	timeout time.Duration
+}
+
+func newClient(servers []string, timeout time.Duration) *client {
+	c := &client{
+		ring:    hashring.New(servers, 0),
+		pools:   make(map[string]*pool, len(servers)),
+		timeout: timeout,
+	}
+	for _, server := range servers {
+		c.pools[server] = &pool{addr: server, idle: make(chan *conn, maxIdle)}
+	}
+	return c
+}
+
+// maxIdle is the number of idle connections kept open to each server.
+const maxIdle = 8
+
+type pool struct {
+	addr string
+	idle chan *conn
+}
+
+type conn struct {
+	nc net.Conn
+	rw *bufio.ReadWriter
+}
+
+func (p *pool) get(timeout time.Duration) (*conn, error) {
+	select {
+	case cn := <-p.idle:
+		return cn, nil
+	default:
+	}
+	network := "tcp"
+	if strings.HasPrefix(p.addr, "/") {
+		network = "unix"
+	}
+	nc, err := net.DialTimeout(network, p.addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{nc: nc, rw: bufio.NewReadWriter(bufio.NewReader(nc), bufio.NewWriter(nc))}, nil
+}
+
+func (p *pool) put(cn *conn) {
+	select {
+	case p.idle <- cn:
+	default:
+		cn.nc.Close()
+	}
+}
+
+// do runs fn on a connection to the server for key. Connections are only
+// reused if fn leaves them in a known state.
+func (c *client) do(key string, fn func(rw *bufio.ReadWriter) error) error {
+	p := c.pools[c.ring.Get(key)]
+	if p == nil {
+		return errors.New("memcache: no servers")
+	}
+	cn, err := p.get(c.timeout)
+	if err != nil {
+		return err
+	}
+	if c.timeout > 0 {
+		cn.nc.SetDeadline(time.Now().Add(c.timeout))
+	}
+	err = fn(cn.rw)
+	if err == nil || err == errCacheMiss || err == errNotStored || err == errCASExists || err == errTooLarge {
+		p.put(cn)
+	} else {
+		cn.nc.Close()
+	}
+	return err
+}
+
+func (c *client) gets(key string) (*item, error) {
+	var it *item
+	err := c.do(key, func(rw *bufio.ReadWriter) error {
+		if _, err := fmt.Fprintf(rw, "gets %s\r\n", key); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		for {
+			line, err := rw.ReadSlice('\n')
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(line, []byte("END\r\n")) {
+				break
+			}
+			// VALUE <key> <flags> <bytes> <cas unique>
+			fields := strings.Fields(string(line))
+			if len(fields) != 5 || fields[0] != "VALUE" {
+				return fmt.Errorf("memcache: unexpected response %q", line)
+			}
+			size, err1 := strconv.Atoi(fields[3])
+			cas, err2 := strconv.ParseUint(fields[4], 10, 64)
+			if err1 != nil || err2 != nil {
+				return fmt.Errorf("memcache: unexpected response %q", line)
+			}
+			value := make([]byte, size+2)
+			if _, err := io.ReadFull(rw, value); err != nil {
+				return err
+			}
+			it = &item{value: value[:size], cas: cas}
+		}
+		if it == nil {
+			return errCacheMiss
+		}
+		return nil
+	})
+	return it, err
+}
+
+// store runs a storage command: "set", "add" or "cas", which also takes
+// the cas unique of the item it replaces.
+func (c *client) store(cmd, key string, value []byte, exptime int64, cas uint64) error {
+	return c.do(key, func(rw *bufio.ReadWriter) error {
+		var err error
+		if cmd == "cas" {
+			_, err = fmt.Fprintf(rw, "cas %s 0 %d %d %d\r\n", key, exptime, len(value), cas)
+		} else {
+			_, err = fmt.Fprintf(rw, "%s %s 0 %d %d\r\n", cmd, key, exptime, len(value))
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := rw.Write(value); err != nil {
+			return err
+		}
+		if _, err := rw.WriteString("\r\n"); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		return readResult(rw)
+	})
+}
+
+func (c *client) delete(key string) error {
+	return c.do(key, func(rw *bufio.ReadWriter) error {
+		if _, err := fmt.Fprintf(rw, "delete %s\r\n", key); err != nil {
+			return err
+		}
+		if err := rw.Flush(); err != nil {
+			return err
+		}
+		return readResult(rw)
+	})
+}
+
+func readResult(rw *bufio.ReadWriter) error {
+	line, err := rw.ReadSlice('\n')
+	if err != nil {
+		return err
+	}
+	switch result := strings.TrimSpace(string(line)); {
+	case result == "STORED" || result == "DELETED":
+		return nil
+	case result == "NOT_STORED":
+		return errNotStored
+	case result == "EXISTS":
+		return errCASExists
+	case result == "NOT_FOUND":
+		return errCacheMiss
+	case strings.HasPrefix(result, "SERVER_ERROR object too large"):
+		return errTooLarge
+	default:
+		return fmt.Errorf("memcache: %s", result)
+	}
+}
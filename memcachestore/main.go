@@ -0,0 +1,218 @@
+package memcachestore
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/gorilla/securecookie"
+	gSessions "github.com/gorilla/sessions"
+)
+
+const (
+	// DefaultTimeout limits dialling and each round trip to a server.
+	DefaultTimeout = time.Second
+
+	// KeyPrefix is prepended to session IDs to form memcached keys.
+	KeyPrefix = "session_"
+
+	// casKey is the session value key under which the CAS unique of a
+	// loaded session is kept until it is saved. It is never stored.
+	casKey = "_cas"
+
+	// maxRelativeExpiry is the longest expiry memcached takes as a number
+	// of seconds; longer ones must be given as a Unix time.
+	maxRelativeExpiry = 30 * 24 * 60 * 60
+)
+
+// Store is a session store that keeps sessions in memcached.
+type Store interface {
+	nSessions.Store
+	nSessions.LoggerSetter
+}
+
+// New returns a new memcached store for the given servers, given as
+// host:port addresses or Unix socket paths. Sessions are spread across the
+// servers by consistent hashing on their ID, so adding or removing a
+// server only loses the sessions that it held.
+//
+// Sessions expire after Options.MaxAge. They are saved with check-and-set,
+// so a session that was modified by another request since it was loaded is
+// not overwritten; such saves fail with ErrConflict.
+func New(servers []string, keyPairs ...[]byte) Store {
+	return &memcacheStore{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Token:  nSessions.NewCookieToken(),
+		client: newClient(servers, DefaultTimeout),
+		options: &gSessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		ids: nSessions.DefaultIDGenerator,
+	}
+}
+
+type memcacheStore struct {
+	Codecs     []securecookie.Codec
+	Token      nSessions.TokenGetSetter
+	client     *client
+	options    *gSessions.Options
+	logger     *slog.Logger
+	ids        nSessions.IDGenerator
+	compressor nSessions.Compressor
+}
+
+func (m *memcacheStore) Options(options nSessions.Options) {
+	m.options = &gSessions.Options{
+		Path:     options.Path,
+		Domain:   options.Domain,
+		MaxAge:   options.MaxAge,
+		Secure:   options.Secure,
+		HttpOnly: options.HTTPOnly,
+	}
+}
+
+// IDGenerator sets the generator of new session IDs.
+func (m *memcacheStore) IDGenerator(ids nSessions.IDGenerator) {
+	m.ids = ids
+}
+
+// Compression sets the compressor applied to session values before they
+// are stored. Values stored with or without compression remain readable.
+func (m *memcacheStore) Compression(c nSessions.Compressor) {
+	m.compressor = c
+}
+
+func (m *memcacheStore) Logger(logger *slog.Logger) {
+	m.logger = logger
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (m *memcacheStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(m, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (m *memcacheStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	session := gSessions.NewSession(m, name)
+	options := *m.options
+	session.Options = &options
+	session.IsNew = true
+	var err error
+	if cook, errToken := m.Token.GetToken(r, name); errToken == nil {
+		err = securecookie.DecodeMulti(name, cook, &session.ID, m.Codecs...)
+		if err == nil {
+			err = m.load(session)
+			session.IsNew = err != nil
+		}
+		if err != nil {
+			// A new session must not take on an ID chosen by the client
+			session.ID = ""
+			err = m.fail(r, "load", name, err)
+		}
+	}
+	return session, err
+}
+
+// Save adds a single session to the response.
+func (m *memcacheStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	if session.Options.MaxAge < 0 {
+		if err := m.delete(session); err != nil && err != errCacheMiss {
+			return m.fail(r, "delete", session.Name(), err)
+		}
+		m.Token.SetToken(w, session.Name(), "", session.Options)
+		return nil
+	}
+
+	if session.ID == "" {
+		id, err := m.ids.NewID()
+		if err != nil {
+			return m.fail(r, "save", session.Name(), err)
+		}
+		session.ID = id
+		session.IsNew = true
+	}
+	if err := m.save(session); err != nil {
+		return m.fail(r, "save", session.Name(), err)
+	}
+	session.IsNew = false
+
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, m.Codecs...)
+	if err != nil {
+		return m.fail(r, "save", session.Name(), err)
+	}
+	m.Token.SetToken(w, session.Name(), encoded, session.Options)
+	return nil
+}
+
+// fail maps err onto the nSessions error kinds and reports it to the logger.
+func (m *memcacheStore) fail(r *http.Request, op, name string, err error) error {
+	switch err {
+	case errCacheMiss:
+		err = nSessions.NewError("memcachestore", op, nSessions.ErrNotFound, err)
+	case errNotStored, errCASExists:
+		err = nSessions.NewError("memcachestore", op, nSessions.ErrConflict, err)
+	case errTooLarge:
+		err = nSessions.NewError("memcachestore", op, nSessions.ErrTooLarge, err)
+	default:
+		err = nSessions.WrapError("memcachestore", op, err)
+	}
+	nSessions.LogError(m.logger, r, "memcachestore", op, name, err)
+	return err
+}
+
+func (m *memcacheStore) load(session *gSessions.Session) error {
+	if !nSessions.ValidID(session.ID) {
+		return nSessions.ErrInvalidId
+	}
+	it, err := m.client.gets(KeyPrefix + session.ID)
+	if err != nil {
+		return err
+	}
+	if err := nSessions.DecodeValues(session.Name(), string(it.value), &session.Values,
+		m.Codecs...); err != nil {
+		return err
+	}
+	session.Values[casKey] = it.cas
+	return nil
+}
+
+func (m *memcacheStore) save(session *gSessions.Session) error {
+	if !nSessions.ValidID(session.ID) {
+		return nSessions.ErrInvalidId
+	}
+
+	cas, _ := session.Values[casKey].(uint64)
+	delete(session.Values, casKey)
+	encoded, err := nSessions.EncodeValues(session.Name(), session.Values, m.compressor, m.Codecs...)
+	if err != nil {
+		return err
+	}
+
+	exptime := int64(session.Options.MaxAge)
+	if exptime > maxRelativeExpiry {
+		exptime = time.Now().Unix() + exptime
+	}
+	key := KeyPrefix + session.ID
+	switch {
+	case session.IsNew:
+		return m.client.store("add", key, []byte(encoded), exptime, 0)
+	case cas != 0:
+		err = m.client.store("cas", key, []byte(encoded), exptime, cas)
+		if err != nil {
+			session.Values[casKey] = cas
+		}
+		return err
+	}
+	// Saved before in this request, when the new CAS unique is not returned
+	return m.client.store("set", key, []byte(encoded), exptime, 0)
+}
+
+func (m *memcacheStore) delete(session *gSessions.Session) error {
+	if !nSessions.ValidID(session.ID) {
+		return nSessions.ErrInvalidId
+	}
+	return m.client.delete(KeyPrefix + session.ID)
+}
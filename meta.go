@@ -0,0 +1,88 @@
+package sessions
+
+import (
+	"encoding/gob"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// metaKey is the session value key under which Meta is kept while a session
+// is in memory. Stores that persist metadata as separate fields remove it
+// from the values they encode.
+const metaKey = "_meta"
+
+func init() {
+	gob.Register(Meta{})
+}
+
+// Meta holds metadata recorded alongside the values of a session.
+type Meta struct {
+	// Created is the time the session was first created.
+	Created time.Time
+	// LastAccess is the time the session was last saved by the middleware,
+	// or loaded if Config.TouchInterval is set. Loading only updates it once
+	// it is older than the interval, to spare the store a write on every
+	// request.
+	LastAccess time.Time
+	// IP is the address of the client that last saved the session.
+	IP string
+	// UserAgent is the User-Agent of the client that last saved the session.
+	UserAgent string
+}
+
+// GetMeta returns the metadata of the given session.
+func GetMeta(s *sessions.Session) Meta {
+	if s == nil || s.Values == nil {
+		return Meta{}
+	}
+	m, _ := s.Values[metaKey].(Meta)
+	return m
+}
+
+// SetMeta sets the metadata of the given session.
+func SetMeta(s *sessions.Session, m Meta) {
+	if s.Values == nil {
+		s.Values = make(map[interface{}]interface{})
+	}
+	s.Values[metaKey] = m
+}
+
+// StripMeta returns a copy of values without the session metadata, for stores
+// that persist Meta separately from the encoded values.
+func StripMeta(values map[interface{}]interface{}) map[interface{}]interface{} {
+	stripped := make(map[interface{}]interface{}, len(values))
+	for k, v := range values {
+		if k == metaKey {
+			continue
+		}
+		stripped[k] = v
+	}
+	return stripped
+}
+
+// touchMeta records the client of the request in the session metadata,
+// setting the creation time if the session has none yet.
+func touchMeta(s *sessions.Session, r *http.Request, accessed bool) {
+	m := GetMeta(s)
+	now := time.Now()
+	if m.Created.IsZero() {
+		m.Created = now
+	}
+	if accessed {
+		m.LastAccess = now
+	}
+	m.IP = clientIP(r)
+	m.UserAgent = r.UserAgent()
+	SetMeta(s, m)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,175 @@
+package metricstore
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// LatencyBuckets are the upper bounds in seconds of the operation latency histogram.
+	LatencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	// PayloadBuckets are the upper bounds in bytes of the payload size histogram.
+	PayloadBuckets = []float64{64, 256, 1024, 4096, 16384, 65536, 262144}
+)
+
+// Collector is an in-process Metrics implementation that serves what it has
+// collected in the Prometheus text exposition format.
+type Collector struct {
+	namespace string
+
+	mu         sync.Mutex
+	operations map[[2]string]uint64
+	latencies  map[string]*histogram
+	payload    *histogram
+	sessions   map[bool]uint64
+}
+
+// NewCollector returns a Collector whose metric names are prefixed with namespace.
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		namespace:  namespace,
+		operations: make(map[[2]string]uint64),
+		latencies:  make(map[string]*histogram),
+		payload:    newHistogram(PayloadBuckets),
+		sessions:   make(map[bool]uint64),
+	}
+}
+
+// ObserveOperation implements Metrics.
+func (c *Collector) ObserveOperation(op string, class string, latency time.Duration) {
+	if class == "" {
+		class = "ok"
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.operations[[2]string{op, class}]++
+	h, ok := c.latencies[op]
+	if !ok {
+		h = newHistogram(LatencyBuckets)
+		c.latencies[op] = h
+	}
+	h.observe(latency.Seconds())
+}
+
+// ObservePayload implements Metrics.
+func (c *Collector) ObservePayload(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.payload.observe(float64(size))
+}
+
+// ObserveSession implements Metrics.
+func (c *Collector) ObserveSession(isNew bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sessions[isNew]++
+}
+
+// ServeHTTP writes the collected metrics for scraping.
+func (c *Collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	c.WriteTo(w)
+}
+
+// WriteTo writes the collected metrics to w in the Prometheus text format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	p := &printer{w: w}
+	name := c.name("operations_total")
+	p.printf("# HELP %s Session store operations by outcome.\n# TYPE %s counter\n", name, name)
+	keys := make([][2]string, 0, len(c.operations))
+	for k := range c.operations {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0]+" "+keys[i][1] < keys[j][0]+" "+keys[j][1]
+	})
+	for _, k := range keys {
+		p.printf("%s{op=%q,class=%q} %d\n", name, k[0], k[1], c.operations[k])
+	}
+
+	name = c.name("operation_duration_seconds")
+	p.printf("# HELP %s Session store operation latency.\n# TYPE %s histogram\n", name, name)
+	ops := make([]string, 0, len(c.latencies))
+	for op := range c.latencies {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		c.latencies[op].write(p, name, fmt.Sprintf("op=%q,", op))
+	}
+
+	name = c.name("payload_bytes")
+	p.printf("# HELP %s Size of saved session values.\n# TYPE %s histogram\n", name, name)
+	c.payload.write(p, name, "")
+
+	name = c.name("sessions_loaded_total")
+	p.printf("# HELP %s Loaded sessions by whether they were new.\n# TYPE %s counter\n", name, name)
+	p.printf("%s{new=\"false\"} %d\n", name, c.sessions[false])
+	p.printf("%s{new=\"true\"} %d\n", name, c.sessions[true])
+
+	return p.n, p.err
+}
+
+func (c *Collector) name(metric string) string {
+	if c.namespace == "" {
+		return metric
+	}
+	return c.namespace + "_" + metric
+}
+
+type histogram struct {
+	bounds []float64
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+func newHistogram(bounds []float64) *histogram {
+	return &histogram{bounds: bounds, counts: make([]uint64, len(bounds))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, bound := range h.bounds {
+		if v <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+func (h *histogram) write(p *printer, name, labels string) {
+	for i, bound := range h.bounds {
+		le := strconv.FormatFloat(bound, 'g', -1, 64)
+		p.printf("%s_bucket{%sle=%q} %d\n", name, labels, le, h.counts[i])
+	}
+	p.printf("%s_bucket{%sle=\"+Inf\"} %d\n", name, labels, h.count)
+	if labels != "" {
+		labels = "{" + labels[:len(labels)-1] + "}"
+	}
+	p.printf("%s_sum%s %g\n", name, labels, h.sum)
+	p.printf("%s_count%s %d\n", name, labels, h.count)
+}
+
+type printer struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.n += int64(n)
+	p.err = err
+}
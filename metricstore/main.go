@@ -0,0 +1,80 @@
+// Package metricstore instruments a session store, reporting operation
+// counts, latencies, errors and payload sizes to a Metrics implementation.
+package metricstore
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	gSessions "github.com/gorilla/sessions"
+)
+
+// Operations reported to Metrics.
+const (
+	OpLoad   = "load"
+	OpSave   = "save"
+	OpDelete = "delete"
+)
+
+// Metrics receives measurements from an instrumented store.
+type Metrics interface {
+	// ObserveOperation records the outcome and latency of a load, save or delete.
+	// The class is that given by sessions.ErrorClass, empty on success.
+	ObserveOperation(op string, class string, latency time.Duration)
+	// ObservePayload records the gob-encoded size in bytes of saved session values.
+	ObservePayload(size int)
+	// ObserveSession records whether a loaded session was new.
+	ObserveSession(isNew bool)
+}
+
+// New returns a store that reports to metrics on every operation of store.
+func New(store nSessions.Store, metrics Metrics) nSessions.Store {
+	return &metricStore{store, metrics}
+}
+
+type metricStore struct {
+	nSessions.Store
+	metrics Metrics
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (m *metricStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(m, name)
+}
+
+// Ping checks the backend of the wrapped store.
+func (m *metricStore) Ping(ctx context.Context) error {
+	return nSessions.Ping(ctx, m.Store)
+}
+
+// New loads a session from the wrapped store, recording the outcome.
+func (m *metricStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	start := time.Now()
+	session, err := m.Store.New(r, name)
+	m.metrics.ObserveOperation(OpLoad, nSessions.ErrorClass(err), time.Since(start))
+	if session != nil {
+		m.metrics.ObserveSession(session.IsNew)
+	}
+	return session, err
+}
+
+// Save saves or deletes a session in the wrapped store, recording the outcome.
+func (m *metricStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	op := OpSave
+	if session.Options != nil && session.Options.MaxAge < 0 {
+		op = OpDelete
+	}
+
+	start := time.Now()
+	err := m.Store.Save(r, w, session)
+	m.metrics.ObserveOperation(op, nSessions.ErrorClass(err), time.Since(start))
+	if err == nil && op == OpSave {
+		if size, ok := nSessions.PayloadSize(session.Values); ok {
+			m.metrics.ObservePayload(size)
+		}
+	}
+	return err
+}
@@ -0,0 +1,180 @@
+// Package migratestore moves sessions from one store to another as they are
+// used, so that a deployment can change session backends without logging
+// everyone out.
+package migratestore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/goincremental/negroni-sessions/internal/writer"
+	gSessions "github.com/gorilla/sessions"
+)
+
+// migratedKey is the session value key under which the ID of a session in
+// the old store is kept until the session is saved to the new store.
+const migratedKey = "_migrated"
+
+// Counter is implemented by stores that can count the sessions they hold.
+type Counter interface {
+	Count(ctx context.Context) (int, error)
+}
+
+// Stats counts the sessions loaded by a migrating store since it was
+// created.
+type Stats struct {
+	// FromNew is the number of sessions loaded from the new store.
+	FromNew int64
+	// Migrated is the number of sessions loaded from the old store and
+	// copied to the new one.
+	Migrated int64
+	// Failed is the number of sessions found in the old store that could
+	// not be saved to the new one. They are tried again on their next use.
+	Failed int64
+}
+
+// Store is a session store migrating sessions between two stores.
+type Store interface {
+	nSessions.Store
+	nSessions.DirtyChecker
+	// DeleteMigrated sets whether sessions are deleted from the old store
+	// once they have been saved to the new one. By default they are left
+	// to expire.
+	DeleteMigrated(bool)
+	// Stats returns the counts of sessions loaded so far.
+	Stats() Stats
+	// Remaining returns the number of sessions held by the old store, if it
+	// implements Counter. Unless DeleteMigrated is set this includes the
+	// sessions that have already been migrated.
+	Remaining(ctx context.Context) (int, error)
+}
+
+// New returns a store that loads sessions from newStore, falling back to
+// oldStore, and saves them only to newStore. Sessions found in oldStore are
+// copied to newStore by the middleware in the same request.
+//
+// Both stores must use the same session name and cookie keys, so that
+// either can read the other's cookies. The migrating store must be the
+// outermost store given to the middleware, as other decorators hide its
+// DirtyChecker.
+func New(newStore, oldStore nSessions.Store) Store {
+	return &migrateStore{Store: newStore, old: oldStore}
+}
+
+type migrateStore struct {
+	nSessions.Store
+	old       nSessions.Store
+	deleteOld bool
+	fromNew   atomic.Int64
+	migrated  atomic.Int64
+	failed    atomic.Int64
+}
+
+func (m *migrateStore) Options(options nSessions.Options) {
+	m.Store.Options(options)
+	m.old.Options(options)
+}
+
+func (m *migrateStore) DeleteMigrated(deleteOld bool) {
+	m.deleteOld = deleteOld
+}
+
+func (m *migrateStore) Stats() Stats {
+	return Stats{
+		FromNew:  m.fromNew.Load(),
+		Migrated: m.migrated.Load(),
+		Failed:   m.failed.Load(),
+	}
+}
+
+func (m *migrateStore) Remaining(ctx context.Context) (int, error) {
+	c, ok := m.old.(Counter)
+	if !ok {
+		return 0, errors.New("migratestore: old store cannot count its sessions")
+	}
+	return c.Count(ctx)
+}
+
+// Dirty reports whether session was loaded from the old store and has yet
+// to be saved to the new one.
+func (m *migrateStore) Dirty(session *gSessions.Session) bool {
+	_, ok := session.Values[migratedKey]
+	return ok
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (m *migrateStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(m, name)
+}
+
+// Ping checks the backends of both stores, as sessions are loaded from
+// either.
+func (m *migrateStore) Ping(ctx context.Context) error {
+	errNew := nSessions.Ping(ctx, m.Store)
+	errOld := nSessions.Ping(ctx, m.old)
+	switch {
+	case errNew != nil && !errors.Is(errNew, nSessions.ErrPingUnsupported):
+		return errNew
+	case errOld != nil && !errors.Is(errOld, nSessions.ErrPingUnsupported):
+		return errOld
+	case errNew != nil && errOld != nil:
+		return nSessions.ErrPingUnsupported
+	}
+	return nil
+}
+
+// New loads a session from the new store, or else from the old store.
+func (m *migrateStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	session, err := m.Store.New(r, name)
+	if err == nil && !session.IsNew {
+		m.fromNew.Add(1)
+		return session, nil
+	}
+
+	old, errOld := m.old.New(r, name)
+	if errOld != nil || old == nil || old.IsNew {
+		return session, err
+	}
+	migrated := gSessions.NewSession(m, name)
+	// An existing session to the application, though without an ID in the
+	// new store until it is saved there. Newer gorilla/sessions releases
+	// mark sessions from NewSession as new.
+	migrated.IsNew = false
+	migrated.Values = old.Values
+	migrated.Values[migratedKey] = old.ID
+	options := *old.Options
+	migrated.Options = &options
+	return migrated, nil
+}
+
+// Save saves the session to the new store. Sessions loaded from the old
+// store are deleted from it afterwards if DeleteMigrated is set.
+func (m *migrateStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	oldID, migrating := session.Values[migratedKey].(string)
+	delete(session.Values, migratedKey)
+	err := m.Store.Save(r, w, session)
+	if !migrating {
+		return err
+	}
+	if err != nil {
+		m.failed.Add(1)
+		session.Values[migratedKey] = oldID
+		return err
+	}
+	m.migrated.Add(1)
+
+	if m.deleteOld && oldID != "" {
+		old := gSessions.NewSession(m.old, session.Name())
+		old.ID = oldID
+		options := *session.Options
+		options.MaxAge = -1
+		old.Options = &options
+		// The old store's cookie must not replace the one just set
+		m.old.Save(r, writer.Discard, old)
+	}
+	return nil
+}
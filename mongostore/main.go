@@ -1,6 +1,8 @@
 package mongostore
 
 import (
+	"context"
+	"log/slog"
 	"net/http"
 	"time"
 
@@ -11,8 +13,21 @@ import (
 	"gopkg.in/mgo.v2/bson"
 )
 
-// New returns a new mongo store
-func New(session mgo.Session, database string, collection string, maxAge int, ensureTTL bool, keyPairs ...[]byte) nSessions.Store {
+// Store is a session store that keeps sessions in MongoDB.
+type Store interface {
+	nSessions.Store
+	nSessions.LoggerSetter
+}
+
+// New returns a new mongo store.
+//
+// Sessions are keyed by IDs from nSessions.DefaultIDGenerator. Sessions
+// created by earlier versions of the store, keyed by an ObjectId, are still
+// loaded and are moved to a new random ID the next time they are saved.
+//
+// Unless ensureTTL is set, expired sessions are kept until they are
+// deleted with DeleteExpired, e.g. by a nSessions.Sweeper.
+func New(session mgo.Session, database string, collection string, maxAge int, ensureTTL bool, keyPairs ...[]byte) Store {
 
 	if ensureTTL {
 		conn := session.Clone()
@@ -35,6 +50,7 @@ func New(session mgo.Session, database string, collection string, maxAge int, en
 		options: &gSessions.Options{
 			MaxAge: maxAge,
 		},
+		ids: nSessions.DefaultIDGenerator,
 	}
 }
 
@@ -48,10 +64,29 @@ func (m *mongoStore) Options(options nSessions.Options) {
 	}
 }
 
+// IDGenerator sets the generator of new session IDs.
+func (m *mongoStore) IDGenerator(ids nSessions.IDGenerator) {
+	m.ids = ids
+}
+
+// Compression sets the compressor applied to session values before they
+// are stored. Values stored with or without compression remain readable.
+func (m *mongoStore) Compression(c nSessions.Compressor) {
+	m.compressor = c
+}
+
 type mongoSession struct {
-	ID       bson.ObjectId `bson:"_id,omitempty"`
-	Data     string
-	Modified time.Time
+	ID         interface{} `bson:"_id,omitempty"`
+	Data       string
+	Modified   time.Time
+	Created    time.Time
+	LastAccess time.Time
+	IP         string
+	UserAgent  string
+}
+
+func (m *mongoStore) Logger(logger *slog.Logger) {
+	m.logger = logger
 }
 
 type mongoStore struct {
@@ -61,6 +96,9 @@ type mongoStore struct {
 	database   string
 	collection string
 	options    *gSessions.Options
+	logger     *slog.Logger
+	ids        nSessions.IDGenerator
+	compressor nSessions.Compressor
 }
 
 //Implementation of gorilla/sessions.Store interface
@@ -82,9 +120,13 @@ func (m *mongoStore) New(r *http.Request, name string) (*gSessions.Session, erro
 	if cook, errToken := m.Token.GetToken(r, name); errToken == nil {
 		err = securecookie.DecodeMulti(name, cook, &session.ID, m.Codecs...)
 		if err == nil {
-			ok, err := m.load(session)
+			var ok bool
+			ok, err = m.load(session)
 			session.IsNew = !(err == nil && ok) // not new if no error and data available
 		}
+		if err != nil {
+			err = m.fail(r, "load", name, err)
+		}
 	}
 	return session, err
 }
@@ -92,32 +134,63 @@ func (m *mongoStore) New(r *http.Request, name string) (*gSessions.Session, erro
 func (m *mongoStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
 	if session.Options.MaxAge < 0 {
 		if err := m.delete(session); err != nil {
-			return err
+			return m.fail(r, "delete", session.Name(), err)
 		}
 		m.Token.SetToken(w, session.Name(), "", session.Options)
 		return nil
 	}
 
+	var legacyID string
+	if bson.IsObjectIdHex(session.ID) {
+		// Move sessions keyed by an ObjectId to a random ID
+		legacyID = session.ID
+		session.ID = ""
+	}
 	if session.ID == "" {
-		session.ID = bson.NewObjectId().Hex()
+		id, err := m.ids.NewID()
+		if err != nil {
+			return m.fail(r, "save", session.Name(), err)
+		}
+		session.ID = id
 	}
 
 	if err := m.save(session); err != nil {
-		return err
+		return m.fail(r, "save", session.Name(), err)
+	}
+
+	if legacyID != "" {
+		err := m.remove(bson.ObjectIdHex(legacyID))
+		if err != nil && err != mgo.ErrNotFound {
+			m.fail(r, "delete", session.Name(), err)
+		}
 	}
 
 	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID,
 		m.Codecs...)
 	if err != nil {
-		return err
+		return m.fail(r, "save", session.Name(), err)
 	}
 
 	m.Token.SetToken(w, session.Name(), encoded, session.Options)
 	return nil
 }
 
+// fail maps err onto the nSessions error kinds and reports it to the logger.
+func (m *mongoStore) fail(r *http.Request, op, name string, err error) error {
+	switch {
+	case err == mgo.ErrNotFound:
+		err = nSessions.NewError("mongostore", op, nSessions.ErrNotFound, err)
+	case err.Error() == "no reachable servers":
+		err = nSessions.NewError("mongostore", op, nSessions.ErrBackendUnavailable, err)
+	default:
+		err = nSessions.WrapError("mongostore", op, err)
+	}
+	nSessions.LogError(m.logger, r, "mongostore", op, name, err)
+	return err
+}
+
 func (m *mongoStore) load(session *gSessions.Session) (bool, error) {
-	if !bson.IsObjectIdHex(session.ID) {
+	if !nSessions.ValidID(session.ID) {
 		return false, nSessions.ErrInvalidId
 	}
 
@@ -127,21 +200,30 @@ func (m *mongoStore) load(session *gSessions.Session) (bool, error) {
 	c := db.C(m.collection)
 
 	s := mongoSession{}
-	err := c.FindId(bson.ObjectIdHex(session.ID)).One(&s)
+	err := c.FindId(session.ID).One(&s)
+	if err == mgo.ErrNotFound && bson.IsObjectIdHex(session.ID) {
+		err = c.FindId(bson.ObjectIdHex(session.ID)).One(&s)
+	}
 	if err != nil {
 		return false, err
 	}
 
-	if err := securecookie.DecodeMulti(session.Name(), s.Data, &session.Values,
+	if err := nSessions.DecodeValues(session.Name(), s.Data, &session.Values,
 		m.Codecs...); err != nil {
 		return false, err
 	}
+	nSessions.SetMeta(session, nSessions.Meta{
+		Created:    s.Created,
+		LastAccess: s.LastAccess,
+		IP:         s.IP,
+		UserAgent:  s.UserAgent,
+	})
 
 	return true, nil
 }
 
 func (m *mongoStore) save(session *gSessions.Session) error {
-	if !bson.IsObjectIdHex(session.ID) {
+	if !nSessions.ValidID(session.ID) {
 		return nSessions.ErrInvalidId
 	}
 
@@ -155,15 +237,20 @@ func (m *mongoStore) save(session *gSessions.Session) error {
 		modified = time.Now()
 	}
 
-	encoded, err := securecookie.EncodeMulti(session.Name(), session.Values,
-		m.Codecs...)
+	encoded, err := nSessions.EncodeValues(session.Name(),
+		nSessions.StripMeta(session.Values), m.compressor, m.Codecs...)
 	if err != nil {
 		return err
 	}
 
+	meta := nSessions.GetMeta(session)
 	s := mongoSession{
-		Data:     encoded,
-		Modified: modified,
+		Data:       encoded,
+		Modified:   modified,
+		Created:    meta.Created,
+		LastAccess: meta.LastAccess,
+		IP:         meta.IP,
+		UserAgent:  meta.UserAgent,
 	}
 
 	connection := m.session.Clone()
@@ -171,7 +258,7 @@ func (m *mongoStore) save(session *gSessions.Session) error {
 	db := connection.DB(m.database)
 	c := db.C(m.collection)
 
-	_, err = c.UpsertId(bson.ObjectIdHex(session.ID), &s)
+	_, err = c.UpsertId(session.ID, &s)
 	if err != nil {
 		return err
 	}
@@ -179,13 +266,56 @@ func (m *mongoStore) save(session *gSessions.Session) error {
 	return nil
 }
 
+// Rekeys reports whether saving session moves it to a new ID, as it is
+// keyed by a legacy ObjectId.
+func (m *mongoStore) Rekeys(session *gSessions.Session) bool {
+	return bson.IsObjectIdHex(session.ID)
+}
+
+// Ping checks that the database can be reached.
+func (m *mongoStore) Ping(ctx context.Context) error {
+	connection := m.session.Clone()
+	defer connection.Close()
+	if err := connection.Ping(); err != nil {
+		return nSessions.NewError("mongostore", "ping", nSessions.ErrBackendUnavailable, err)
+	}
+	return nil
+}
+
+// Count returns the number of sessions in the collection, including expired
+// ones not yet removed by the TTL index.
+func (m *mongoStore) Count(ctx context.Context) (int, error) {
+	connection := m.session.Clone()
+	defer connection.Close()
+	return connection.DB(m.database).C(m.collection).Count()
+}
+
+// DeleteExpired deletes the sessions last modified before the given time.
+// It is only needed if the TTL index is not used.
+func (m *mongoStore) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	connection := m.session.Clone()
+	defer connection.Close()
+	info, err := connection.DB(m.database).C(m.collection).RemoveAll(bson.M{"modified": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, nSessions.WrapError("mongostore", "sweep", err)
+	}
+	return info.Removed, nil
+}
+
 func (m *mongoStore) delete(session *gSessions.Session) error {
-	if !bson.IsObjectIdHex(session.ID) {
+	if !nSessions.ValidID(session.ID) {
 		return nSessions.ErrInvalidId
 	}
+	if bson.IsObjectIdHex(session.ID) {
+		return m.remove(bson.ObjectIdHex(session.ID))
+	}
+	return m.remove(session.ID)
+}
+
+func (m *mongoStore) remove(id interface{}) error {
 	connection := m.session.Clone()
 	defer connection.Close()
 	db := connection.DB(m.database)
 	c := db.C(m.collection)
-	return c.RemoveId(bson.ObjectIdHex(session.ID))
+	return c.RemoveId(id)
 }
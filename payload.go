@@ -0,0 +1,23 @@
+package sessions
+
+import (
+	"encoding/gob"
+)
+
+type countingWriter int
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	*c += countingWriter(len(p))
+	return len(p), nil
+}
+
+// PayloadSize returns the size in bytes of session values once gob encoded,
+// the serialization used by the bundled stores. It returns false if the
+// values cannot be encoded.
+func PayloadSize(values map[interface{}]interface{}) (int, bool) {
+	var c countingWriter
+	if err := gob.NewEncoder(&c).Encode(values); err != nil {
+		return 0, false
+	}
+	return int(c), true
+}
@@ -1,22 +1,33 @@
 package redisstore
 
 import (
+	"context"
+	"log/slog"
+	"net/http"
+
 	"github.com/boj/redistore"
 	nSessions "github.com/goincremental/negroni-sessions"
 	gSessions "github.com/gorilla/sessions"
 )
 
+// Store is a session store that keeps sessions in Redis.
+type Store interface {
+	nSessions.Store
+	nSessions.LoggerSetter
+}
+
 //New returns a new Redis store
-func New(size int, network, address, password string, keyPairs ...[]byte) (nSessions.Store, error) {
+func New(size int, network, address, password string, keyPairs ...[]byte) (Store, error) {
 	store, err := redistore.NewRediStore(size, network, address, password, keyPairs...)
 	if err != nil {
 		return nil, err
 	}
-	return &rediStore{store}, nil
+	return &rediStore{RediStore: store}, nil
 }
 
 type rediStore struct {
 	*redistore.RediStore
+	logger *slog.Logger
 }
 
 func (c *rediStore) Options(options nSessions.Options) {
@@ -28,3 +39,45 @@ func (c *rediStore) Options(options nSessions.Options) {
 		HttpOnly: options.HTTPOnly,
 	}
 }
+
+func (c *rediStore) Logger(logger *slog.Logger) {
+	c.logger = logger
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (c *rediStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(c, name)
+}
+
+// New returns a session for the given name without adding it to the registry.
+func (c *rediStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	session, err := c.RediStore.New(r, name)
+	err = nSessions.WrapError("redisstore", "load", err)
+	nSessions.LogError(c.logger, r, "redisstore", "load", name, err)
+	return session, err
+}
+
+// Save adds a single session to the response.
+func (c *rediStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	err := c.RediStore.Save(r, w, session)
+	if err != nil {
+		op := "save"
+		if session.Options != nil && session.Options.MaxAge < 0 {
+			op = "delete"
+		}
+		err = nSessions.WrapError("redisstore", op, err)
+		nSessions.LogError(c.logger, r, "redisstore", op, session.Name(), err)
+	}
+	return err
+}
+
+// Ping checks that Redis can be reached.
+func (c *rediStore) Ping(ctx context.Context) error {
+	conn := c.Pool.Get()
+	defer conn.Close()
+	if _, err := conn.Do("PING"); err != nil {
+		return nSessions.NewError("redisstore", "ping", nSessions.ErrBackendUnavailable, err)
+	}
+	return nil
+}
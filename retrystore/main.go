@@ -0,0 +1,171 @@
+// Package retrystore retries loading and saving sessions when a store's
+// backend fails transiently, e.g. during a network blip or a failover.
+package retrystore
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/goincremental/negroni-sessions/internal/writer"
+	gSessions "github.com/gorilla/sessions"
+)
+
+// Defaults for a retrying store.
+const (
+	DefaultAttempts = 3
+	DefaultBase     = 20 * time.Millisecond
+	DefaultMax      = 500 * time.Millisecond
+	DefaultBudget   = 2 * time.Second
+)
+
+// Option configures a retrying store.
+type Option func(*retryStore)
+
+// WithAttempts sets the most times an operation is tried, including the
+// first.
+func WithAttempts(attempts int) Option {
+	return func(s *retryStore) {
+		s.attempts = attempts
+	}
+}
+
+// WithBackoff sets the delay before the first retry, which doubles with
+// each further retry up to max. The actual delay is drawn at random below
+// it, so that clients that failed together do not retry together.
+func WithBackoff(base, max time.Duration) Option {
+	return func(s *retryStore) {
+		s.base, s.max = base, max
+	}
+}
+
+// WithBudget sets the total time an operation may take across its
+// attempts. Attempts are made with a request context that expires at the
+// end of the budget, and no retry is made that would overrun it. A budget
+// of zero or less leaves operations bounded only by the request context.
+func WithBudget(budget time.Duration) Option {
+	return func(s *retryStore) {
+		s.budget = budget
+	}
+}
+
+// WithClassifier sets the function deciding whether an error is transient
+// and the operation worth retrying. By default only errors of the
+// ClassUnavailable class are retried.
+func WithClassifier(retryable func(error) bool) Option {
+	return func(s *retryStore) {
+		s.retryable = retryable
+	}
+}
+
+// Transient reports whether err means the backend could not be reached.
+func Transient(err error) bool {
+	return nSessions.ErrorClass(err) == nSessions.ClassUnavailable
+}
+
+// New returns a store that retries failed loads and saves of store with
+// exponential backoff.
+//
+// A save that reached the backend before failing may be retried, so
+// stores that save conditionally, such as memcachestore, can report a
+// retried save of a new session as a conflict.
+func New(store nSessions.Store, options ...Option) nSessions.Store {
+	s := &retryStore{
+		Store:     store,
+		attempts:  DefaultAttempts,
+		base:      DefaultBase,
+		max:       DefaultMax,
+		budget:    DefaultBudget,
+		retryable: Transient,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+type retryStore struct {
+	nSessions.Store
+	attempts  int
+	base, max time.Duration
+	budget    time.Duration
+	retryable func(error) bool
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (s *retryStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(s, name)
+}
+
+// Ping checks the backend of the wrapped store.
+func (s *retryStore) Ping(ctx context.Context) error {
+	return nSessions.Ping(ctx, s.Store)
+}
+
+// New loads a session from the wrapped store, retrying transient failures.
+func (s *retryStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	var session *gSessions.Session
+	err := s.retry(r, func(r *http.Request) error {
+		var err error
+		session, err = s.Store.New(r, name)
+		return err
+	})
+	return session, err
+}
+
+// Save saves a session to the wrapped store, retrying transient failures.
+// Only the headers written by the final attempt reach the response.
+func (s *retryStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	var header http.Header
+	err := s.retry(r, func(r *http.Request) error {
+		header = make(http.Header)
+		return s.Store.Save(r, writer.Headers(w, header), session)
+	})
+	writer.Copy(w, header)
+	return err
+}
+
+// retry calls op with r bounded by the budget until it succeeds, fails
+// permanently or runs out of attempts or time.
+func (s *retryStore) retry(r *http.Request, op func(*http.Request) error) error {
+	ctx, cancel := r.Context(), context.CancelFunc(func() {})
+	if s.budget > 0 {
+		ctx, cancel = context.WithTimeout(ctx, s.budget)
+	}
+	defer cancel()
+	deadline, bounded := ctx.Deadline()
+	r = r.WithContext(ctx)
+
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = op(r); err == nil || attempt+1 >= s.attempts || !s.retryable(err) {
+			return err
+		}
+		delay := s.delay(attempt)
+		if bounded && time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+// delay returns a random delay below the backoff for the given attempt.
+func (s *retryStore) delay(attempt int) time.Duration {
+	backoff := s.max
+	if attempt < 32 && s.base<<attempt < s.max {
+		backoff = s.base << attempt
+	}
+	if backoff <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(backoff)))
+}
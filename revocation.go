@@ -0,0 +1,23 @@
+package sessions
+
+import (
+	"context"
+	"time"
+)
+
+// Revocation is a list of revoked session IDs. Stores that keep sessions on
+// the client, where a stolen cookie cannot otherwise be invalidated before
+// it expires, consult it when loading a session.
+type Revocation interface {
+	// Revoke adds id to the list until the given time, after which the
+	// session would have expired anyway. A zero time revokes it for good.
+	Revoke(ctx context.Context, id string, until time.Time) error
+	// Revoked reports whether id is on the list.
+	Revoked(ctx context.Context, id string) (bool, error)
+}
+
+// RevocationSetter is implemented by stores that can consult a Revocation
+// list when loading sessions.
+type RevocationSetter interface {
+	Revocation(Revocation)
+}
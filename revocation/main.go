@@ -0,0 +1,48 @@
+// Package revocation provides in-memory, Redis and SQL backed revocation
+// lists of session IDs, for use with stores that keep sessions on the client.
+package revocation
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+)
+
+// NewMemory returns a revocation list held in memory. It is only suitable
+// for a single process, and is lost when the process exits.
+func NewMemory() nSessions.Revocation {
+	return &memory{ids: make(map[string]time.Time)}
+}
+
+type memory struct {
+	mu    sync.RWMutex
+	ids   map[string]time.Time
+	purge time.Time
+}
+
+func (m *memory) Revoke(ctx context.Context, id string, until time.Time) error {
+	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ids[id] = until
+
+	// Drop lapsed entries now and then, so the list does not grow forever
+	if now.After(m.purge) {
+		for id, until := range m.ids {
+			if !until.IsZero() && now.After(until) {
+				delete(m.ids, id)
+			}
+		}
+		m.purge = now.Add(time.Minute)
+	}
+	return nil
+}
+
+func (m *memory) Revoked(ctx context.Context, id string) (bool, error) {
+	m.mu.RLock()
+	until, ok := m.ids[id]
+	m.mu.RUnlock()
+	return ok && (until.IsZero() || time.Now().Before(until)), nil
+}
@@ -0,0 +1,42 @@
+package revocation
+
+import (
+	"context"
+	"time"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/gomodule/redigo/redis"
+)
+
+// NewRedis returns a revocation list kept in Redis under keys made of
+// prefix and the session ID. Entries expire from Redis with the sessions
+// they revoke. The pool of a redisstore can be shared.
+func NewRedis(pool *redis.Pool, prefix string) nSessions.Revocation {
+	return &redisList{pool: pool, prefix: prefix}
+}
+
+type redisList struct {
+	pool   *redis.Pool
+	prefix string
+}
+
+func (l *redisList) Revoke(ctx context.Context, id string, until time.Time) error {
+	args := []interface{}{l.prefix + id, 1}
+	if !until.IsZero() {
+		ttl := int64(time.Until(until) / time.Second)
+		if ttl <= 0 {
+			return nil
+		}
+		args = append(args, "EX", ttl)
+	}
+	conn := l.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", args...)
+	return err
+}
+
+func (l *redisList) Revoked(ctx context.Context, id string) (bool, error) {
+	conn := l.pool.Get()
+	defer conn.Close()
+	return redis.Bool(conn.Do("EXISTS", l.prefix+id))
+}
@@ -0,0 +1,84 @@
+package revocation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// SQL is a revocation list kept in a database table with an id and an
+// expires column, holding the Unix time after which the entry lapses or 0.
+type SQL struct {
+	db          *sql.DB
+	table       string
+	placeholder func(n int) string
+}
+
+// NewSQL returns a revocation list kept in the given table. placeholder
+// returns the bind parameter for the nth argument of a query; nil uses "?",
+// as MySQL and SQLite expect, and DollarPlaceholder suits PostgreSQL.
+func NewSQL(db *sql.DB, table string, placeholder func(n int) string) *SQL {
+	if placeholder == nil {
+		placeholder = func(int) string { return "?" }
+	}
+	return &SQL{db: db, table: table, placeholder: placeholder}
+}
+
+// DollarPlaceholder numbers bind parameters $1, $2...
+func DollarPlaceholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+// CreateTable creates the table of the list if it does not exist.
+func (s *SQL) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (id VARCHAR(128) PRIMARY KEY, expires BIGINT NOT NULL)", s.table))
+	return err
+}
+
+func (s *SQL) Revoke(ctx context.Context, id string, until time.Time) error {
+	var expires int64
+	if !until.IsZero() {
+		expires = until.Unix()
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = %s",
+		s.table, s.placeholder(1)), id); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf("INSERT INTO %s (id, expires) VALUES (%s, %s)",
+		s.table, s.placeholder(1), s.placeholder(2)), id, expires); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQL) Revoked(ctx context.Context, id string) (bool, error) {
+	var expires int64
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf("SELECT expires FROM %s WHERE id = %s",
+		s.table, s.placeholder(1)), id).Scan(&expires)
+	switch {
+	case err == sql.ErrNoRows:
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return expires == 0 || time.Now().Unix() < expires, nil
+}
+
+// Purge deletes lapsed entries from the table. It should be run
+// periodically.
+func (s *SQL) Purge(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE expires > 0 AND expires <= %s",
+		s.table, s.placeholder(1)), time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
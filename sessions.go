@@ -25,8 +25,12 @@ package sessions
 
 import (
 	"context"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"time"
 
 	gContext "github.com/gorilla/context"
 	"github.com/gorilla/sessions"
@@ -36,8 +40,7 @@ import (
 type contextKey int
 
 const (
-	errorFormat string     = "[sessions] ERROR! %s\n"
-	sessionKey  contextKey = 0
+	sessionKey contextKey = 0
 )
 
 // Store is an interface for custom session stores.
@@ -46,6 +49,13 @@ type Store interface {
 	Options(Options)
 }
 
+// DirtyChecker is implemented by stores that modify sessions as they load
+// them, e.g. to move them from another store. Sessions it reports as dirty
+// are saved by the middleware even if the handler does not change them.
+type DirtyChecker interface {
+	Dirty(*sessions.Session) bool
+}
+
 // Options stores configuration for a session or session store.
 //
 // Fields are a subset of http.Cookie fields.
@@ -80,14 +90,71 @@ type Session interface {
 	Flashes(vars ...string) []interface{}
 	// Options sets confuguration for a session.
 	Options(Options)
+	// Meta returns the metadata recorded for the session.
+	Meta() Meta
+	// ID returns the ID of the session, or "" if the store has not assigned
+	// one yet.
+	ID() string
+	// Save persists the session immediately rather than when the response
+	// headers are written. The session is saved again at that point only if
+	// it is modified after Save.
+	Save() error
+}
+
+// Config holds optional settings for the Sessions middleware.
+type Config struct {
+	// Binding, if set, binds each session to a fingerprint of the client
+	// that created it.
+	Binding *Binding
+	// Hooks are called on session lifecycle events.
+	Hooks Hooks
+	// Logger receives load and save failures. It defaults to slog.Default().
+	Logger *slog.Logger
+	// TouchInterval, if set, is how stale the LastAccess time of a session
+	// may grow before loading the session updates it, saving the session
+	// even if it is otherwise unchanged. Unless it is set, LastAccess is
+	// only updated when the session is saved, and reads never write.
+	TouchInterval time.Duration
+	// OnDecodeError, if set, is called instead of logging when the session
+	// cookie cannot be decoded, has expired or has been revoked, e.g. after
+	// a key change. In each case the session is treated as new and the bad
+	// cookie is replaced or expired on the response.
+	OnDecodeError func(r *http.Request, err error)
+}
+
+// Hooks are called on session lifecycle events. Any of them may be nil.
+type Hooks struct {
+	// OnCreate is called when a new session is created because none was
+	// found in the store.
+	OnCreate func(r *http.Request, s Session)
+	// OnLoad is called when an existing session is loaded from the store.
+	OnLoad func(r *http.Request, s Session)
+	// OnSave is called after a session has been saved.
+	OnSave func(r *http.Request, s Session)
+	// OnDestroy is called after a session with MaxAge<0 has been removed.
+	OnDestroy func(r *http.Request, s Session)
 }
 
 // Sessions is a Middleware that maps a session.Session service into the negroni handler chain.
 // Sessions can use a number of storage solutions with the given store.
 func Sessions(name string, store Store) negroni.HandlerFunc {
+	return SessionsWithConfig(name, store, Config{})
+}
+
+// SessionsWithConfig is like Sessions but takes additional configuration.
+func SessionsWithConfig(name string, store Store, config Config) negroni.HandlerFunc {
+	logger := config.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
 	return func(res http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
 		// Map to the Session interface
-		s := &session{name, r, store, nil, false}
+		s := newSession(name, res, r, store, &config, logger)
+
+		// Check the session belongs to this client before handing it out
+		if !s.checkBinding(res, config.Binding) {
+			return
+		}
 
 		// Add our session to the context we got from our request
 		ctx := context.WithValue(r.Context(), sessionKey, s)
@@ -95,9 +162,7 @@ func Sessions(name string, store Store) negroni.HandlerFunc {
 		// Use before hook to save out the session
 		rw := res.(negroni.ResponseWriter)
 		rw.Before(func(negroni.ResponseWriter) {
-			if s.Written() {
-				check(s.Session().Save(r, res))
-			}
+			s.saveWritten(res)
 		})
 
 		// Wrap our request with the new context
@@ -107,12 +172,46 @@ func Sessions(name string, store Store) negroni.HandlerFunc {
 	}
 }
 
+func newSession(name string, w http.ResponseWriter, r *http.Request, store Store, config *Config,
+	logger *slog.Logger) *session {
+	return &session{name: name, writer: w, request: r, store: store, config: config, logger: logger}
+}
+
+// checkBinding rejects the request with 403 Forbidden if the session is
+// bound to another client, returning false if it did so.
+func (s *session) checkBinding(w http.ResponseWriter, binding *Binding) bool {
+	if binding != nil && !s.bind(binding) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
 type session struct {
 	name    string
+	writer  http.ResponseWriter
 	request *http.Request
 	store   Store
 	session *sessions.Session
 	written bool
+	config  *Config
+	logger  *slog.Logger
+	// badCookie is set when the request carried an undecodable cookie
+	badCookie bool
+}
+
+// Save persists the session stored in the request context immediately,
+// writing its cookie to w. It is meant for handlers that keep using the
+// session after the response headers have been written, such as streaming
+// responses and hijacked connections; cookie changes made after the headers
+// are written cannot reach the client, so only server-side stores are
+// useful there.
+func Save(w http.ResponseWriter, req *http.Request) error {
+	s, ok := req.Context().Value(sessionKey).(*session)
+	if !ok {
+		return ErrNoSession
+	}
+	return s.saveNow(w)
 }
 
 // GetSession returns the session stored in the request context
@@ -154,7 +253,16 @@ func (s *session) Clear() {
 	if sess == nil {
 		return
 	}
-	sess.Values = nil
+	meta := GetMeta(sess)
+	fingerprint, bound := sess.Values[fingerprintKey]
+	sess.Values = make(map[interface{}]interface{})
+	if !meta.Created.IsZero() {
+		SetMeta(sess, meta)
+	}
+	if bound {
+		// Clearing the session's values must not unbind it
+		sess.Values[fingerprintKey] = fingerprint
+	}
 	s.written = true
 	gContext.Clear(s.request)
 }
@@ -191,22 +299,145 @@ func (s *session) Options(options Options) {
 	}
 }
 
+func (s *session) Save() error {
+	return s.saveNow(s.writer)
+}
+
+// saveNow saves the session and marks it as unmodified, so that it is not
+// saved again unless it changes.
+func (s *session) saveNow(w http.ResponseWriter) error {
+	if err := s.save(w); err != nil {
+		return err
+	}
+	s.written = false
+	return nil
+}
+
+func (s *session) Meta() Meta {
+	return GetMeta(s.Session())
+}
+
+func (s *session) ID() string {
+	return s.Session().ID
+}
+
 func (s *session) Session() *sessions.Session {
 	if s.session == nil {
 		var err error
 		s.session, err = s.store.Get(s.request, s.name)
-		check(err)
+		if s.session != nil && (errors.Is(err, ErrTampered) || errors.Is(err, ErrExpired) ||
+			errors.Is(err, ErrRevoked)) {
+			s.recover(err)
+		} else if err != nil {
+			s.logError("load", err)
+		}
+		if s.session != nil && s.session.IsNew {
+			touchMeta(s.session, s.request, false)
+			s.hook(s.config.Hooks.OnCreate)
+		} else if s.session != nil {
+			s.touch()
+			s.hook(s.config.Hooks.OnLoad)
+		}
+		if d, ok := s.store.(DirtyChecker); ok && s.session != nil && d.Dirty(s.session) {
+			s.written = true
+		}
 	}
 
 	return s.session
 }
 
+// touch records an access to a loaded session, marking it for saving if
+// a touch interval is set and its LastAccess time is older than it.
+func (s *session) touch() {
+	interval := s.config.TouchInterval
+	m := GetMeta(s.session)
+	if interval <= 0 || time.Since(m.LastAccess) < interval {
+		return
+	}
+	m.LastAccess = time.Now()
+	SetMeta(s.session, m)
+	s.written = true
+}
+
+// save writes the session out to its store and fires the matching hook.
+func (s *session) save(w http.ResponseWriter) error {
+	sess := s.Session()
+	touchMeta(sess, s.request, true)
+	if err := s.store.Save(s.request, w, sess); err != nil {
+		return err
+	}
+	// The bad cookie has been replaced
+	s.badCookie = false
+	if sess.Options != nil && sess.Options.MaxAge < 0 {
+		s.hook(s.config.Hooks.OnDestroy)
+	} else {
+		s.hook(s.config.Hooks.OnSave)
+	}
+	return nil
+}
+
+// saveWritten saves the session if it has been modified, logging failures.
+func (s *session) saveWritten(w http.ResponseWriter) {
+	if s.Written() {
+		if err := s.save(w); err != nil {
+			s.logError("save", err)
+		}
+	} else if s.badCookie {
+		s.expireCookie(w)
+	}
+}
+
+// recover treats a session whose cookie could not be decoded as new, so the
+// bad cookie is replaced or expired instead of being sent forever.
+func (s *session) recover(err error) {
+	s.session.ID = ""
+	s.session.IsNew = true
+	s.session.Values = make(map[interface{}]interface{})
+	s.badCookie = true
+	if s.config.OnDecodeError != nil {
+		s.config.OnDecodeError(s.request, err)
+	} else {
+		s.logError("load", err)
+	}
+}
+
+// expireCookie expires the session cookie, along with the continuation
+// cookies a chunking store such as cookiestore splits large values into.
+func (s *session) expireCookie(w http.ResponseWriter) {
+	options := sessions.Options{MaxAge: -1}
+	if s.session.Options != nil {
+		options = *s.session.Options
+		options.MaxAge = -1
+	}
+	http.SetCookie(w, sessions.NewCookie(s.name, "", &options))
+	for i := 1; ; i++ {
+		name := s.name + "_" + strconv.Itoa(i)
+		if _, err := s.request.Cookie(name); err != nil {
+			break
+		}
+		http.SetCookie(w, sessions.NewCookie(name, "", &options))
+	}
+}
+
+func (s *session) hook(h func(*http.Request, Session)) {
+	if h != nil {
+		h(s.request, s)
+	}
+}
+
 func (s *session) Written() bool {
 	return s.written
 }
 
-func check(err error) {
-	if err != nil {
-		log.Printf(errorFormat, err)
+// logError logs a failure returned by the store, unless the store has
+// logged it already.
+func (s *session) logError(op string, err error) {
+	var e *Error
+	if s.logger == nil || err == nil || errors.As(err, &e) && e.logged {
+		return
+	}
+	if op == "save" && s.session != nil && s.session.Options != nil && s.session.Options.MaxAge < 0 {
+		op = "delete"
 	}
+	logRecord(s.logger, s.request, fmt.Sprintf("%T", s.store), op, s.name, err)
 }
@@ -1,15 +1,48 @@
 package sessions_test
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/goincremental/negroni-sessions"
+	"github.com/goincremental/negroni-sessions/asyncstore"
+	"github.com/goincremental/negroni-sessions/cachestore"
+	"github.com/goincremental/negroni-sessions/compress"
 	"github.com/goincremental/negroni-sessions/cookiestore"
+	"github.com/goincremental/negroni-sessions/dynamostore"
+	"github.com/goincremental/negroni-sessions/failoverstore"
+	"github.com/goincremental/negroni-sessions/jwtstore"
+	"github.com/goincremental/negroni-sessions/memcachestore"
+	"github.com/goincremental/negroni-sessions/metricstore"
+	"github.com/goincremental/negroni-sessions/migratestore"
+	"github.com/goincremental/negroni-sessions/retrystore"
+	"github.com/goincremental/negroni-sessions/revocation"
+	"github.com/goincremental/negroni-sessions/shardstore"
+	"github.com/goincremental/negroni-sessions/tracestore"
+	"github.com/gorilla/securecookie"
+	gSessions "github.com/gorilla/sessions"
 	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func Test_Sessions(t *testing.T) {
@@ -218,3 +251,1849 @@ func Test_SessionsClear(t *testing.T) {
 	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
 	n.ServeHTTP(res2, req2)
 }
+
+func Test_SessionsMeta(t *testing.T) {
+	n := negroni.Classic()
+
+	store := cookiestore.New([]byte("secret123"))
+	n.Use(sessions.Sessions("my_session", store))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/testsession", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		session.Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		meta := sessions.GetSession(req).Meta()
+		if meta.Created.IsZero() || meta.LastAccess.IsZero() {
+			t.Error("Session meta times not recorded:", meta)
+		}
+		if meta.UserAgent != "negroni-test" {
+			t.Error("Session meta user agent not recorded:", meta.UserAgent)
+		}
+		if meta.IP != "192.0.2.1" {
+			t.Error("Session meta ip not recorded:", meta.IP)
+		}
+		fmt.Fprintf(w, "OK")
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	req.Header.Set("User-Agent", "negroni-test")
+	n.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	n.ServeHTTP(res2, req2)
+}
+
+func Test_SessionsLastAccess(t *testing.T) {
+	store := cookiestore.New([]byte("secret123"))
+	var lastAccess time.Time
+	server := func(interval time.Duration) http.Handler {
+		n := negroni.New()
+		n.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{TouchInterval: interval}))
+		n.UseHandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			session := sessions.GetSession(req)
+			if req.URL.Path == "/set" {
+				session.Set("hello", "world")
+			}
+			lastAccess = session.Meta().LastAccess
+			fmt.Fprintf(w, "OK")
+		})
+		return n
+	}
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	server(time.Hour).ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", requestCookies(res))
+	server(time.Hour).ServeHTTP(res2, req2)
+	if cookie := res2.Header().Get("Set-Cookie"); cookie != "" {
+		t.Error("Recently accessed session saved on read:", cookie)
+	}
+	saved := lastAccess
+
+	stale := gSessions.NewSession(store, "my_session")
+	stale.Options = &gSessions.Options{MaxAge: 3600}
+	sessions.SetMeta(stale, sessions.Meta{LastAccess: time.Now().Add(-2 * time.Hour)})
+	resStale := httptest.NewRecorder()
+	if err := store.Save(req, resStale, stale); err != nil {
+		t.Fatal("Saving failed:", err)
+	}
+	resUnset := httptest.NewRecorder()
+	reqUnset, _ := http.NewRequest("GET", "/show", nil)
+	reqUnset.Header.Set("Cookie", requestCookies(resStale))
+	server(0).ServeHTTP(resUnset, reqUnset)
+	if cookie := resUnset.Header().Get("Set-Cookie"); cookie != "" {
+		t.Error("Session saved on read without a touch interval:", cookie)
+	}
+
+	time.Sleep(time.Millisecond)
+	res3 := httptest.NewRecorder()
+	server(time.Nanosecond).ServeHTTP(res3, req2)
+	if res3.Header().Get("Set-Cookie") == "" {
+		t.Fatal("Stale last access not saved on read")
+	}
+	if !lastAccess.After(saved) {
+		t.Error("Last access not updated on read:", saved, lastAccess)
+	}
+
+	res4 := httptest.NewRecorder()
+	req4, _ := http.NewRequest("GET", "/show", nil)
+	req4.Header.Set("Cookie", requestCookies(res3))
+	server(time.Hour).ServeHTTP(res4, req4)
+	if !lastAccess.After(saved) {
+		t.Error("Updated last access not stored:", saved, lastAccess)
+	}
+}
+
+func Test_SessionsBindingAfterClear(t *testing.T) {
+	store := cookiestore.New([]byte("secret123"))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		session.Clear()
+		session.Set("user", "victim")
+		fmt.Fprintf(w, "OK")
+	})
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Get("user")
+		fmt.Fprintf(w, "OK")
+	})
+	unbound := negroni.New()
+	unbound.Use(sessions.Sessions("my_session", store))
+	unbound.UseHandler(mux)
+	bound := negroni.New()
+	bound.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{Binding: &sessions.Binding{}}))
+	bound.UseHandler(mux)
+
+	serve := func(n http.Handler, path, agent, cookies string) *httptest.ResponseRecorder {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", path, nil)
+		req.Header.Set("User-Agent", agent)
+		req.Header.Set("Cookie", cookies)
+		n.ServeHTTP(res, req)
+		return res
+	}
+
+	res := serve(bound, "/set", "client-a", "")
+	res2 := serve(bound, "/login", "client-a", requestCookies(res))
+	if res3 := serve(bound, "/show", "client-b", requestCookies(res2)); res3.Code != http.StatusForbidden {
+		t.Error("Session cleared at login not bound:", res3.Code)
+	}
+
+	// A session created before binding was enabled is bound on its next use
+	res4 := serve(unbound, "/set", "client-a", "")
+	res5 := serve(bound, "/show", "client-a", requestCookies(res4))
+	if res5.Header().Get("Set-Cookie") == "" {
+		t.Fatal("Binding of an existing session not saved")
+	}
+	if res6 := serve(bound, "/show", "client-b", requestCookies(res5)); res6.Code != http.StatusForbidden {
+		t.Error("Existing session not bound:", res6.Code)
+	}
+}
+
+func Test_SessionsBinding(t *testing.T) {
+	for _, regenerate := range []bool{false, true} {
+		n := negroni.Classic()
+		mismatches := 0
+
+		store := cookiestore.New([]byte("secret123"))
+		n.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{
+			Binding: &sessions.Binding{
+				Regenerate: regenerate,
+				OnMismatch: func(*http.Request, sessions.Session) { mismatches++ },
+			},
+		}))
+
+		mux := http.NewServeMux()
+		mux.HandleFunc("/testsession", func(w http.ResponseWriter, req *http.Request) {
+			sessions.GetSession(req).Set("hello", "world")
+			fmt.Fprintf(w, "OK")
+		})
+
+		mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+			if sessions.GetSession(req).Get("hello") != nil {
+				t.Error("Session from another client was not regenerated")
+			}
+			fmt.Fprintf(w, "OK")
+		})
+
+		n.UseHandler(mux)
+
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/testsession", nil)
+		req.Header.Set("User-Agent", "client-a")
+		n.ServeHTTP(res, req)
+
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", "/show", nil)
+		req2.Header.Set("User-Agent", "client-b")
+		req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+		n.ServeHTTP(res2, req2)
+
+		if mismatches != 1 {
+			t.Error("Mismatch callback count does not equal 1. Equals ", mismatches)
+		}
+		if !regenerate && res2.Code != http.StatusForbidden {
+			t.Error("Mismatched session was not rejected:", res2.Code)
+		}
+		if regenerate && res2.Code != http.StatusOK {
+			t.Error("Mismatched session was not regenerated:", res2.Code)
+		}
+	}
+}
+
+func Test_SessionsHooks(t *testing.T) {
+	n := negroni.Classic()
+	events := []string{}
+	record := func(event string) func(*http.Request, sessions.Session) {
+		return func(*http.Request, sessions.Session) { events = append(events, event) }
+	}
+
+	store := cookiestore.New([]byte("secret123"))
+	n.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{
+		Hooks: sessions.Hooks{
+			OnCreate:  record("create"),
+			OnLoad:    record("load"),
+			OnSave:    record("save"),
+			OnDestroy: record("destroy"),
+		},
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/testsession", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/logout", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		session.Options(sessions.Options{MaxAge: -1})
+		session.Clear()
+		fmt.Fprintf(w, "OK")
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	n.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/logout", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	n.ServeHTTP(res2, req2)
+
+	if strings.Join(events, ",") != "create,save,load,destroy" {
+		t.Error("Unexpected lifecycle events:", events)
+	}
+}
+
+func Test_MetricStore(t *testing.T) {
+	n := negroni.Classic()
+
+	collector := metricstore.NewCollector("sessions")
+	store := metricstore.New(cookiestore.New([]byte("secret123")), collector)
+	n.Use(sessions.Sessions("my_session", store))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/testsession", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	n.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/testsession", nil)
+	req2.Header.Set("Cookie", "my_session=tampered")
+	n.ServeHTTP(res2, req2)
+
+	out := httptest.NewRecorder()
+	collector.ServeHTTP(out, nil)
+	for _, line := range []string{
+		`sessions_operations_total{op="load",class="ok"} 1`,
+		`sessions_operations_total{op="load",class="decode"} 1`,
+		`sessions_operations_total{op="save",class="ok"} 2`,
+		`sessions_operation_duration_seconds_count{op="save"} 2`,
+		`sessions_payload_bytes_count 2`,
+		`sessions_sessions_loaded_total{new="true"} 2`,
+	} {
+		if !strings.Contains(out.Body.String(), line) {
+			t.Error("Metrics output missing", line)
+		}
+	}
+}
+
+func Test_SessionsLogger(t *testing.T) {
+	n := negroni.Classic()
+	var buf bytes.Buffer
+
+	store := cookiestore.New([]byte("secret123"))
+	n.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{
+		Logger: slog.New(slog.NewTextHandler(&buf, nil)),
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Get("hello")
+		fmt.Fprintf(w, "OK")
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/show", nil)
+	req.Header.Set("Cookie", "my_session=tampered")
+	req.Header.Set("X-Request-Id", "abc123")
+	n.ServeHTTP(res, req)
+
+	for _, attr := range []string{"level=WARN", "session=my_session", "op=load", "error_class=decode", "request_id=abc123"} {
+		if !strings.Contains(buf.String(), attr) {
+			t.Error("Log record missing", attr, buf.String())
+		}
+	}
+}
+
+func Test_SessionsLoggerOnce(t *testing.T) {
+	var storeBuf, buf bytes.Buffer
+	store := cookiestore.New([]byte("secret123"))
+	store.Logger(slog.New(slog.NewTextHandler(&storeBuf, nil)))
+	store.MaxSize(1)
+	n := negroni.Classic()
+	n.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{
+		Logger:        slog.New(slog.NewTextHandler(&buf, nil)),
+		OnDecodeError: func(r *http.Request, err error) {},
+	}))
+	n.UseHandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", "my_session=tampered")
+	n.ServeHTTP(res, req)
+
+	if !strings.Contains(storeBuf.String(), "error_class=too_large") {
+		t.Error("Save failure not logged by the store:", storeBuf.String())
+	}
+	if strings.Contains(storeBuf.String(), "op=load") {
+		t.Error("Decode error logged by the store despite callback:", storeBuf.String())
+	}
+	if buf.Len() != 0 {
+		t.Error("Failure logged by the store logged again:", buf.String())
+	}
+}
+
+func Test_Handler(t *testing.T) {
+	store := cookiestore.New([]byte("secret123"))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/testsession", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/silent", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "silence")
+	})
+
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, sessions.GetSession(req).Get("hello"))
+	})
+
+	h := sessions.Handler("my_session", store)(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	h.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", res.Header().Get("Set-Cookie"))
+	h.ServeHTTP(res2, req2)
+	if res2.Body.String() != "world" {
+		t.Error("Session writing failed:", res2.Body.String())
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/silent", nil)
+	h.ServeHTTP(res3, req3)
+	if res3.Header().Get("Set-Cookie") == "" {
+		t.Error("Session not saved for a response without a body")
+	}
+}
+
+func Test_SessionsSave(t *testing.T) {
+	n := negroni.Classic()
+	saves := 0
+
+	store := cookiestore.New([]byte("secret123"))
+	n.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{
+		Hooks: sessions.Hooks{
+			OnSave: func(*http.Request, sessions.Session) { saves++ },
+		},
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/testsession", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		session.Set("hello", "world")
+		if err := session.Save(); err != nil {
+			t.Error("Session save failed:", err)
+		}
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "OK")
+		w.(http.Flusher).Flush()
+		sessions.GetSession(req).Set("hello", "stream")
+		if err := sessions.Save(w, req); err != nil {
+			t.Error("Session save failed:", err)
+		}
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/testsession", nil)
+	n.ServeHTTP(res, req)
+	if saves != 1 {
+		t.Error("Saved session was saved again. Saves ", saves)
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/stream", nil)
+	n.ServeHTTP(res2, req2)
+	if saves != 2 {
+		t.Error("Session was not saved after flush. Saves ", saves)
+	}
+
+	req3, _ := http.NewRequest("GET", "/", nil)
+	if err := sessions.Save(httptest.NewRecorder(), req3); err != sessions.ErrNoSession {
+		t.Error("Save without a session did not fail:", err)
+	}
+}
+
+func Test_RandomIDGenerator(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		id, err := sessions.DefaultIDGenerator.NewID()
+		if err != nil {
+			t.Fatal("ID generation failed:", err)
+		}
+		if len(id) != 43 || !sessions.ValidID(id) {
+			t.Error("Generated ID is not 256 bits of base64:", id)
+		}
+		if seen[id] {
+			t.Error("Generated ID repeated:", id)
+		}
+		seen[id] = true
+	}
+	if sessions.ValidID("") || sessions.ValidID("bad id") || sessions.ValidID(strings.Repeat("a", 129)) {
+		t.Error("Invalid IDs accepted")
+	}
+}
+
+func Test_Errors(t *testing.T) {
+	store := cookiestore.New([]byte("secret123"))
+
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", "my_session=tampered")
+	_, err := store.New(req, "my_session")
+	if !errors.Is(err, sessions.ErrTampered) {
+		t.Error("Tampered cookie not reported as ErrTampered:", err)
+	}
+	if class := sessions.ErrorClass(err); class != sessions.ClassDecode {
+		t.Error("Tampered cookie has class", class)
+	}
+	var sessionErr *sessions.Error
+	if !errors.As(err, &sessionErr) || sessionErr.Store != "cookiestore" || sessionErr.Op != "load" {
+		t.Error("Store error does not record the store and operation:", err)
+	}
+
+	err = sessions.NewError("mongostore", "load", sessions.ErrNotFound, errors.New("not found"))
+	if !errors.Is(err, sessions.ErrNotFound) || sessions.ErrorClass(err) != sessions.ClassNotFound {
+		t.Error("Not found error not classified:", err)
+	}
+
+	err = sessions.WrapError("redisstore", "save", &net.OpError{Op: "dial", Err: errors.New("refused")})
+	if !errors.Is(err, sessions.ErrBackendUnavailable) {
+		t.Error("Network error not reported as ErrBackendUnavailable:", err)
+	}
+}
+
+func Test_SessionsBadCookie(t *testing.T) {
+	n := negroni.Classic()
+	var decodeErr error
+	var buf bytes.Buffer
+
+	store := cookiestore.New([]byte("secret123"))
+	n.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{
+		Logger:        slog.New(slog.NewTextHandler(&buf, nil)),
+		OnDecodeError: func(r *http.Request, err error) { decodeErr = err },
+	}))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		if sessions.GetSession(req).Get("hello") != nil {
+			t.Error("Session with a bad cookie is not empty")
+		}
+		fmt.Fprintf(w, "OK")
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/show", nil)
+	req.Header.Set("Cookie", "my_session=tampered")
+	n.ServeHTTP(res, req)
+
+	if !errors.Is(decodeErr, sessions.ErrTampered) {
+		t.Error("Decode error callback not called:", decodeErr)
+	}
+	if buf.Len() != 0 {
+		t.Error("Decode error logged despite callback:", buf.String())
+	}
+	if cookie := res.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=0") {
+		t.Error("Bad cookie not expired:", cookie)
+	}
+}
+
+// requestCookies returns a Cookie header carrying the cookies set on res.
+func requestCookies(res *httptest.ResponseRecorder) string {
+	var pairs []string
+	for _, c := range res.Result().Cookies() {
+		if c.MaxAge >= 0 {
+			pairs = append(pairs, c.Name+"="+c.Value)
+		}
+	}
+	return strings.Join(pairs, "; ")
+}
+
+func Test_SessionsBadChunkedCookie(t *testing.T) {
+	n := negroni.New()
+	n.Use(sessions.SessionsWithConfig("my_session", cookiestore.New([]byte("secret123")), sessions.Config{
+		OnDecodeError: func(r *http.Request, err error) {},
+	}))
+	n.UseHandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Get("hello")
+		fmt.Fprintf(w, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", "my_session=tampered; my_session_1=more; my_session_2=more")
+	n.ServeHTTP(res, req)
+
+	expired := make(map[string]bool)
+	for _, cookie := range res.Result().Cookies() {
+		if cookie.MaxAge < 0 {
+			expired[cookie.Name] = true
+		}
+	}
+	for _, name := range []string{"my_session", "my_session_1", "my_session_2"} {
+		if !expired[name] {
+			t.Error("Bad cookie not expired:", name)
+		}
+	}
+}
+
+func Test_SessionsSaveAfterBadCookie(t *testing.T) {
+	n := negroni.New()
+	n.Use(sessions.SessionsWithConfig("my_session", cookiestore.New([]byte("secret123")), sessions.Config{
+		OnDecodeError: func(r *http.Request, err error) {},
+	}))
+	n.UseHandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		session.Set("hello", "world")
+		if err := session.Save(); err != nil {
+			t.Error("Saving failed:", err)
+		}
+		fmt.Fprintf(w, "OK")
+	})
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	req.Header.Set("Cookie", "my_session=garbage")
+	n.ServeHTTP(res, req)
+
+	cookies := res.Result().Cookies()
+	if len(cookies) == 0 {
+		t.Fatal("Session cookie not set")
+	}
+	for _, cookie := range cookies {
+		if cookie.Name == "my_session" && cookie.MaxAge < 0 {
+			t.Error("Replaced cookie expired again:", res.Header()["Set-Cookie"])
+		}
+	}
+}
+
+func Test_CookieStoreChunking(t *testing.T) {
+	n := negroni.Classic()
+
+	store := cookiestore.New([]byte("secret123"))
+	store.MaxSize(3 * cookiestore.ChunkSize)
+	n.Use(sessions.Sessions("my_session", store))
+
+	large := make([]byte, 6000)
+	rand.New(rand.NewSource(1)).Read(large)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/large", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("large", large)
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/small", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		if !bytes.Equal(session.Get("large").([]byte), large) {
+			t.Error("Chunked session not reassembled")
+		}
+		session.Delete("large")
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/toolarge", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		session.Set("large", append(large, large...))
+		if err := session.Save(); !errors.Is(err, sessions.ErrTooLarge) {
+			t.Error("Oversized session not rejected:", err)
+		}
+		fmt.Fprintf(w, "OK")
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/large", nil)
+	n.ServeHTTP(res, req)
+	if len(res.Result().Cookies()) < 2 {
+		t.Fatal("Large session not chunked")
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/small", nil)
+	req2.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res2, req2)
+	for _, c := range res2.Result().Cookies() {
+		if c.Name != "my_session" && c.MaxAge >= 0 {
+			t.Error("Stale chunk not expired:", c.Name)
+		}
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/toolarge", nil)
+	n.ServeHTTP(res3, req3)
+}
+
+func Test_Compression(t *testing.T) {
+	codecs := securecookie.CodecsFromPairs([]byte("secret123"))
+	values := map[interface{}]interface{}{"cart": strings.Repeat("item,", 400)}
+
+	plain, err := sessions.EncodeValues("my_session", values, nil, codecs...)
+	if err != nil {
+		t.Fatal("Encoding failed:", err)
+	}
+	for _, c := range []sessions.Compressor{nil, sessions.Gzip, compress.Zstd, compress.Snappy} {
+		encoded, err := sessions.EncodeValues("my_session", values, c, codecs...)
+		if err != nil {
+			t.Fatal("Encoding failed:", err)
+		}
+		if c != nil && len(encoded) >= len(plain) {
+			t.Error("Values not compressed with marker", c.Marker())
+		}
+
+		var decoded map[interface{}]interface{}
+		if err := sessions.DecodeValues("my_session", encoded, &decoded, codecs...); err != nil {
+			t.Fatal("Decoding failed:", err)
+		}
+		if decoded["cart"] != values["cart"] {
+			t.Error("Values changed by compression round trip")
+		}
+	}
+}
+
+func Test_JWTStore(t *testing.T) {
+	n := negroni.Classic()
+
+	store, err := jwtstore.New(jwtstore.Key{ID: "k1", Algorithm: jwtstore.HS256, Secret: []byte("secret123")})
+	if err != nil {
+		t.Fatal("Creating store failed:", err)
+	}
+	store.Encryption(bytes.Repeat([]byte("k"), 32))
+	n.Use(sessions.Sessions("my_session", store))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/get", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		if session.Get("hello") != "world" {
+			t.Error("Session value not restored from token")
+		}
+		if session.Meta().Created.IsZero() {
+			t.Error("Session metadata not restored from token")
+		}
+		fmt.Fprintf(w, "OK")
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res, req)
+	cookies := requestCookies(res)
+	if strings.Count(cookies, ".") != 4 {
+		t.Fatal("Token not encrypted:", cookies)
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/get", nil)
+	req2.Header.Set("Cookie", cookies)
+	n.ServeHTTP(res2, req2)
+
+	other, _ := jwtstore.New(jwtstore.Key{ID: "k1", Algorithm: jwtstore.HS256, Secret: []byte("other")})
+	other.Encryption(bytes.Repeat([]byte("k"), 32))
+	req3, _ := http.NewRequest("GET", "/get", nil)
+	req3.Header.Set("Cookie", cookies)
+	if _, err := other.New(req3, "my_session"); !errors.Is(err, sessions.ErrTampered) {
+		t.Error("Token signed with another key accepted:", err)
+	}
+}
+
+func Test_CookieStoreRevocation(t *testing.T) {
+	n := negroni.Classic()
+
+	store := cookiestore.New([]byte("secret123"))
+	store.Revocation(revocation.NewMemory())
+	n.Use(sessions.SessionsWithConfig("my_session", store, sessions.Config{
+		OnDecodeError: func(r *http.Request, err error) {},
+	}))
+
+	var id string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		session.Set("user", "alice")
+		if err := session.Save(); err != nil {
+			t.Fatal("Saving session failed:", err)
+		}
+		id = session.ID()
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, sessions.GetSession(req).Get("user"))
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/login", nil)
+	n.ServeHTTP(res, req)
+	if id == "" {
+		t.Fatal("Session not given an ID")
+	}
+	cookies := requestCookies(res)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", cookies)
+	n.ServeHTTP(res2, req2)
+	if res2.Body.String() != "alice" {
+		t.Fatal("Session not loaded before revocation:", res2.Body.String())
+	}
+
+	if err := store.Revoke(req2.Context(), id); err != nil {
+		t.Fatal("Revoking session failed:", err)
+	}
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/show", nil)
+	req3.Header.Set("Cookie", cookies)
+	n.ServeHTTP(res3, req3)
+	if res3.Body.String() != "<nil>" {
+		t.Error("Revoked session loaded:", res3.Body.String())
+	}
+	if cookie := res3.Header().Get("Set-Cookie"); !strings.Contains(cookie, "Max-Age=0") {
+		t.Error("Revoked cookie not expired:", cookie)
+	}
+}
+
+// fakeDynamo keeps DynamoDB items in memory, honouring the conditions used
+// by dynamostore.
+type fakeDynamo struct {
+	items map[string]map[string]types.AttributeValue
+}
+
+func (f *fakeDynamo) id(key map[string]types.AttributeValue) string {
+	return key[dynamostore.AttrID].(*types.AttributeValueMemberS).Value
+}
+
+func (f *fakeDynamo) GetItem(ctx context.Context, in *dynamodb.GetItemInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	return &dynamodb.GetItemOutput{Item: f.items[f.id(in.Key)]}, nil
+}
+
+func (f *fakeDynamo) PutItem(ctx context.Context, in *dynamodb.PutItemInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	id := f.id(in.Item)
+	_, exists := f.items[id]
+	if exists == strings.HasPrefix(*in.ConditionExpression, "attribute_not_exists") {
+		return nil, &types.ConditionalCheckFailedException{}
+	}
+	f.items[id] = in.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (f *fakeDynamo) DeleteItem(ctx context.Context, in *dynamodb.DeleteItemInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	delete(f.items, f.id(in.Key))
+	return &dynamodb.DeleteItemOutput{}, nil
+}
+
+func Test_DynamoStore(t *testing.T) {
+	n := negroni.Classic()
+
+	db := &fakeDynamo{items: make(map[string]map[string]types.AttributeValue)}
+	store := dynamostore.NewWithClient(db, "sessions", []byte("secret123"))
+	n.Use(sessions.Sessions("my_session", store))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, sessions.GetSession(req).Get("hello"))
+	})
+
+	mux.HandleFunc("/stale", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		session.Set("hello", "again")
+		db.items = make(map[string]map[string]types.AttributeValue)
+		if err := session.Save(); !errors.Is(err, sessions.ErrNotFound) {
+			t.Error("Deleted session saved again:", err)
+		}
+		fmt.Fprintf(w, "OK")
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res, req)
+	if len(db.items) != 1 {
+		t.Fatal("Session not stored")
+	}
+	for _, item := range db.items {
+		if _, ok := item[dynamostore.AttrExpires]; !ok {
+			t.Error("TTL attribute not set")
+		}
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res2, req2)
+	if res2.Body.String() != "world" {
+		t.Error("Session not loaded:", res2.Body.String())
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/stale", nil)
+	req3.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res3, req3)
+}
+
+// spanStore starts a backend span under the request context for each load
+// and save, and fails saves if failing is set.
+type spanStore struct {
+	sessions.Store
+	tracer  trace.Tracer
+	failing bool
+}
+
+func (s *spanStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	_, span := s.tracer.Start(r.Context(), "backend.get")
+	defer span.End()
+	return s.Store.New(r, name)
+}
+
+func (s *spanStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	_, span := s.tracer.Start(r.Context(), "backend.put")
+	defer span.End()
+	if s.failing {
+		return sessions.NewError("spanstore", "save", sessions.ErrBackendUnavailable, nil)
+	}
+	return s.Store.Save(r, w, session)
+}
+
+func Test_TraceStore(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	inner := &spanStore{Store: cookiestore.New([]byte("secret123")), tracer: provider.Tracer("test")}
+	n := helloServer(tracestore.New(inner, tracestore.WithTracerProvider(provider)))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res, req)
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	for _, pair := range [][2]string{{"session.load", "backend.get"}, {"session.save", "backend.put"}} {
+		parent, child := spans[pair[0]], spans[pair[1]]
+		if parent == nil || child == nil {
+			t.Fatal("Spans not recorded:", pair)
+		}
+		if child.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Error("Backend span not nested under", pair[0])
+		}
+	}
+	if status := spans["session.save"].Status(); status.Code != codes.Unset {
+		t.Error("Successful save marked as failed:", status)
+	}
+
+	inner.failing = true
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res2, req2)
+	ended := recorder.Ended()
+	failed := ended[len(ended)-1]
+	if failed.Name() != "session.save" || failed.Status().Code != codes.Error {
+		t.Fatal("Failed save not marked as failed:", failed.Name(), failed.Status())
+	}
+	for _, attr := range failed.Attributes() {
+		if attr.Key == tracestore.OutcomeKey && attr.Value.AsString() != sessions.ClassUnavailable {
+			t.Error("Outcome not the error class:", attr.Value.AsString())
+		}
+	}
+}
+
+// fakeTTL reports the TTL of a table as enabled on attribute, recording
+// updates to it.
+type fakeTTL struct {
+	dynamostore.TableClient
+	attribute string
+	updates   int
+}
+
+func (f *fakeTTL) DescribeTimeToLive(ctx context.Context, in *dynamodb.DescribeTimeToLiveInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.DescribeTimeToLiveOutput, error) {
+	ttl := &types.TimeToLiveDescription{TimeToLiveStatus: types.TimeToLiveStatusDisabled}
+	if f.attribute != "" {
+		ttl = &types.TimeToLiveDescription{AttributeName: &f.attribute, TimeToLiveStatus: types.TimeToLiveStatusEnabled}
+	}
+	return &dynamodb.DescribeTimeToLiveOutput{TimeToLiveDescription: ttl}, nil
+}
+
+func (f *fakeTTL) UpdateTimeToLive(ctx context.Context, in *dynamodb.UpdateTimeToLiveInput,
+	optFns ...func(*dynamodb.Options)) (*dynamodb.UpdateTimeToLiveOutput, error) {
+	f.updates++
+	f.attribute = *in.TimeToLiveSpecification.AttributeName
+	return &dynamodb.UpdateTimeToLiveOutput{}, nil
+}
+
+func Test_DynamoStoreTTLAndConflict(t *testing.T) {
+	ctx := context.Background()
+	ttl := &fakeTTL{}
+	if err := dynamostore.EnableTTL(ctx, ttl, "sessions"); err != nil || ttl.updates != 1 {
+		t.Error("TTL not enabled:", err, ttl.updates)
+	}
+	if err := dynamostore.EnableTTL(ctx, ttl, "sessions"); err != nil || ttl.updates != 1 {
+		t.Error("Enabled TTL updated again:", err, ttl.updates)
+	}
+	other := &fakeTTL{attribute: "expires_at"}
+	if err := dynamostore.EnableTTL(ctx, other, "sessions"); err == nil || other.updates != 0 {
+		t.Error("TTL on another attribute not reported:", err, other.updates)
+	}
+
+	db := &fakeDynamo{items: make(map[string]map[string]types.AttributeValue)}
+	store := dynamostore.NewWithClient(db, "sessions", []byte("secret123"))
+	req, _ := http.NewRequest("GET", "/", nil)
+	session := gSessions.NewSession(store, "my_session")
+	session.IsNew, session.Options = true, &gSessions.Options{MaxAge: 3600}
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Fatal("Saving failed:", err)
+	}
+	clash := gSessions.NewSession(store, "my_session")
+	clash.ID, clash.IsNew, clash.Options = session.ID, true, session.Options
+	if err := store.Save(req, httptest.NewRecorder(), clash); !errors.Is(err, sessions.ErrConflict) {
+		t.Error("ID collision not reported as a conflict:", err)
+	}
+}
+
+// fakeMemcached serves the subset of the memcached text protocol used by
+// memcachestore from memory.
+type fakeMemcached struct {
+	mu    sync.Mutex
+	down  bool
+	items map[string][]byte
+	cas   map[string]uint64
+	next  uint64
+}
+
+func startFakeMemcached(t *testing.T) (*fakeMemcached, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal("Listening failed:", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	f := &fakeMemcached{items: make(map[string][]byte), cas: make(map[string]uint64)}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return f, l.Addr().String()
+}
+
+func (f *fakeMemcached) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			fmt.Fprint(c, "ERROR\r\n")
+			continue
+		}
+		f.mu.Lock()
+		if f.down {
+			f.mu.Unlock()
+			return
+		}
+		key := fields[1]
+		switch fields[0] {
+		case "gets":
+			if value, ok := f.items[key]; ok {
+				fmt.Fprintf(c, "VALUE %s 0 %d %d\r\n%s\r\n", key, len(value), f.cas[key], value)
+			}
+			fmt.Fprint(c, "END\r\n")
+		case "delete":
+			if _, ok := f.items[key]; !ok {
+				fmt.Fprint(c, "NOT_FOUND\r\n")
+				break
+			}
+			delete(f.items, key)
+			fmt.Fprint(c, "DELETED\r\n")
+		case "set", "add", "cas":
+			size, _ := strconv.Atoi(fields[4])
+			value := make([]byte, size+2)
+			io.ReadFull(r, value)
+			_, exists := f.items[key]
+			switch {
+			case fields[0] == "add" && exists:
+				fmt.Fprint(c, "NOT_STORED\r\n")
+			case fields[0] == "cas" && !exists:
+				fmt.Fprint(c, "NOT_FOUND\r\n")
+			case fields[0] == "cas" && fields[5] != strconv.FormatUint(f.cas[key], 10):
+				fmt.Fprint(c, "EXISTS\r\n")
+			default:
+				f.next++
+				f.items[key], f.cas[key] = value[:size], f.next
+				fmt.Fprint(c, "STORED\r\n")
+			}
+		default:
+			fmt.Fprint(c, "ERROR\r\n")
+		}
+		f.mu.Unlock()
+	}
+}
+
+func Test_MemcacheStore(t *testing.T) {
+	n := negroni.Classic()
+
+	server1, addr1 := startFakeMemcached(t)
+	server2, addr2 := startFakeMemcached(t)
+	store := memcachestore.New([]string{addr1, addr2}, []byte("secret123"))
+	n.Use(sessions.Sessions("my_session", store))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, sessions.GetSession(req).Get("hello"))
+	})
+
+	mux.HandleFunc("/conflict", func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		session.Set("hello", "again")
+		// Another request saves the session in the meantime
+		other, _ := http.NewRequest("GET", "/set", nil)
+		other.Header = req.Header
+		n.ServeHTTP(httptest.NewRecorder(), other)
+		if err := session.Save(); !errors.Is(err, sessions.ErrConflict) {
+			t.Error("Concurrently modified session overwritten:", err)
+		}
+		fmt.Fprintf(w, "OK")
+	})
+
+	n.UseHandler(mux)
+
+	for i := 0; i < 20; i++ {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/set", nil)
+		n.ServeHTTP(res, req)
+	}
+	if len(server1.items) == 0 || len(server2.items) == 0 {
+		t.Error("Sessions not spread across servers:", len(server1.items), len(server2.items))
+	}
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res, req)
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res2, req2)
+	if res2.Body.String() != "world" {
+		t.Error("Session not loaded:", res2.Body.String())
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/conflict", nil)
+	req3.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res3, req3)
+}
+
+// countingStore counts the sessions loaded from the store it wraps.
+type countingStore struct {
+	sessions.Store
+	loads int
+	saves int
+}
+
+func (c *countingStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	c.loads++
+	return c.Store.New(r, name)
+}
+
+func (c *countingStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	c.saves++
+	return c.Store.Save(r, w, session)
+}
+
+func Test_CacheStore(t *testing.T) {
+	n := negroni.Classic()
+
+	_, addr := startFakeMemcached(t)
+	remote := &countingStore{Store: memcachestore.New([]string{addr}, []byte("secret123"))}
+	store := cachestore.New(remote, 10, time.Minute)
+	var invalidated []string
+	store.OnInvalidate(func(id string) { invalidated = append(invalidated, id) })
+	n.Use(sessions.Sessions("my_session", store))
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, sessions.GetSession(req).Get("hello"))
+	})
+
+	n.UseHandler(mux)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res, req)
+	loads := remote.loads
+
+	for i := 0; i < 3; i++ {
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", "/show", nil)
+		req2.Header.Set("Cookie", requestCookies(res))
+		n.ServeHTTP(res2, req2)
+		if res2.Body.String() != "world" {
+			t.Error("Cached session not loaded:", res2.Body.String())
+		}
+	}
+	if remote.loads != loads {
+		t.Error("Cached session loaded from the wrapped store", remote.loads-loads, "times")
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/set", nil)
+	req3.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res3, req3)
+	if len(invalidated) != 1 {
+		t.Fatal("Invalidation callback not called on save")
+	}
+
+	store.Invalidate(invalidated[0])
+	res4 := httptest.NewRecorder()
+	req4, _ := http.NewRequest("GET", "/show", nil)
+	req4.Header.Set("Cookie", requestCookies(res3))
+	n.ServeHTTP(res4, req4)
+	if remote.loads != loads+1 {
+		t.Error("Invalidated session not loaded from the wrapped store")
+	}
+}
+
+// helloServer serves /set, which stores a value in the session, and /show,
+// which writes it out.
+func helloServer(store sessions.Store) http.Handler {
+	n := negroni.Classic()
+	n.Use(sessions.Sessions("my_session", store))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/set", func(w http.ResponseWriter, req *http.Request) {
+		sessions.GetSession(req).Set("hello", "world")
+		fmt.Fprintf(w, "OK")
+	})
+	mux.HandleFunc("/show", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, sessions.GetSession(req).Get("hello"))
+	})
+	n.UseHandler(mux)
+	return n
+}
+
+func Test_MigrateStore(t *testing.T) {
+	old := cookiestore.New([]byte("secret123"))
+	_, addr := startFakeMemcached(t)
+	store := migratestore.New(memcachestore.New([]string{addr}, []byte("secret123")), old)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	helloServer(old).ServeHTTP(res, req)
+
+	req1, _ := http.NewRequest("GET", "/show", nil)
+	req1.Header.Set("Cookie", requestCookies(res))
+	if session, err := store.New(req1, "my_session"); err != nil || session.IsNew {
+		t.Error("Session loaded from old store is new:", err)
+	}
+
+	n := helloServer(store)
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res2, req2)
+	if res2.Body.String() != "world" {
+		t.Error("Session not loaded from old store:", res2.Body.String())
+	}
+	if stats := store.Stats(); stats.Migrated != 1 {
+		t.Error("Session not migrated:", stats)
+	}
+
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/show", nil)
+	req3.Header.Set("Cookie", requestCookies(res2))
+	n.ServeHTTP(res3, req3)
+	if res3.Body.String() != "world" {
+		t.Error("Session not loaded from new store:", res3.Body.String())
+	}
+	if stats := store.Stats(); stats.FromNew != 1 || stats.Migrated != 1 {
+		t.Error("Migrated session not loaded from new store:", stats)
+	}
+}
+
+func Test_ShardStore(t *testing.T) {
+	server1, addr1 := startFakeMemcached(t)
+	server2, addr2 := startFakeMemcached(t)
+	store, err := shardstore.New(map[string]sessions.Store{
+		"a": memcachestore.New([]string{addr1}, []byte("secret123")),
+		"b": memcachestore.New([]string{addr2}, []byte("secret123")),
+	})
+	if err != nil {
+		t.Fatal("Creating store failed:", err)
+	}
+	n := helloServer(store)
+
+	for i := 0; i < 20; i++ {
+		res := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/set", nil)
+		n.ServeHTTP(res, req)
+
+		cookies := requestCookies(res)
+		if !strings.HasPrefix(cookies, "my_session=a.") && !strings.HasPrefix(cookies, "my_session=b.") {
+			t.Fatal("Shard not named in cookie:", cookies)
+		}
+		res2 := httptest.NewRecorder()
+		req2, _ := http.NewRequest("GET", "/show", nil)
+		req2.Header.Set("Cookie", cookies)
+		n.ServeHTTP(res2, req2)
+		if res2.Body.String() != "world" {
+			t.Error("Session not loaded from its shard:", res2.Body.String())
+		}
+	}
+	if len(server1.items) == 0 || len(server2.items) == 0 {
+		t.Error("Sessions not spread across shards:", len(server1.items), len(server2.items))
+	}
+}
+
+// countingIDs counts the IDs it generates.
+type countingIDs struct {
+	ids int
+}
+
+func (c *countingIDs) NewID() (string, error) {
+	c.ids++
+	return sessions.DefaultIDGenerator.NewID()
+}
+
+func Test_ShardStoreUnsavedSession(t *testing.T) {
+	_, addr := startFakeMemcached(t)
+	store, _ := shardstore.New(map[string]sessions.Store{
+		"a": memcachestore.New([]string{addr}, []byte("secret123")),
+	})
+	ids := &countingIDs{}
+	store.(sessions.IDGeneratorSetter).IDGenerator(ids)
+	n := helloServer(store)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/show", nil)
+	n.ServeHTTP(res, req)
+	if ids.ids != 0 {
+		t.Error("ID generated for a session never saved:", ids.ids)
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res2, req2)
+	if ids.ids != 1 || !strings.HasPrefix(requestCookies(res2), "my_session=a.") {
+		t.Error("Saved session not given an ID:", ids.ids, requestCookies(res2))
+	}
+}
+
+func Test_FailoverStore(t *testing.T) {
+	primary, addr1 := startFakeMemcached(t)
+	secondary, addr2 := startFakeMemcached(t)
+	store := failoverstore.New(memcachestore.New([]string{addr1}, []byte("secret123")),
+		memcachestore.New([]string{addr2}, []byte("secret123")))
+	store.Breaker(1, time.Minute)
+	n := helloServer(store)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res, req)
+	if len(primary.items) != 1 || len(secondary.items) != 1 {
+		t.Fatal("Session not replicated")
+	}
+
+	primary.mu.Lock()
+	primary.down = true
+	primary.mu.Unlock()
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/show", nil)
+	req2.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res2, req2)
+	if res2.Body.String() != "world" {
+		t.Error("Session not loaded from secondary:", res2.Body.String())
+	}
+	if healthy := store.Healthy(); healthy[0] || !healthy[1] {
+		t.Error("Failed primary not skipped:", healthy)
+	}
+
+	secondary.mu.Lock()
+	secondary.down = true
+	secondary.mu.Unlock()
+	res3 := httptest.NewRecorder()
+	req3, _ := http.NewRequest("GET", "/set", nil)
+	req3.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res3, req3)
+	if cookie := res3.Header().Get("Set-Cookie"); cookie != "" {
+		t.Error("Cookie replaced during outage:", cookie)
+	}
+}
+
+func Test_FailoverStoreMissedWrites(t *testing.T) {
+	primary, addr1 := startFakeMemcached(t)
+	_, addr2 := startFakeMemcached(t)
+	store := failoverstore.New(memcachestore.New([]string{addr1}, []byte("secret123")),
+		memcachestore.New([]string{addr2}, []byte("secret123")))
+	setDown := func(down bool) {
+		primary.mu.Lock()
+		primary.down = down
+		primary.mu.Unlock()
+	}
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	helloServer(store).ServeHTTP(res, req)
+	req2, _ := http.NewRequest("GET", "/", nil)
+	req2.Header.Set("Cookie", requestCookies(res))
+
+	setDown(true)
+	session, err := store.New(req2, "my_session")
+	if err != nil {
+		t.Fatal("Loading failed:", err)
+	}
+	session.Values["hello"] = "moon"
+	if err := store.Save(req2, httptest.NewRecorder(), session); err != nil {
+		t.Fatal("Saving failed:", err)
+	}
+	setDown(false)
+	if session, _ := store.New(req2, "my_session"); session.Values["hello"] != "moon" {
+		t.Error("Stale session loaded from store that missed a save:", session.Values["hello"])
+	}
+
+	setDown(true)
+	session.Options.MaxAge = -1
+	if err := store.Save(req2, httptest.NewRecorder(), session); err != nil {
+		t.Fatal("Deleting failed:", err)
+	}
+	setDown(false)
+	if session, _ := store.New(req2, "my_session"); !session.IsNew {
+		t.Error("Deleted session loaded from store that missed the delete:", session.Values)
+	}
+	primary.mu.Lock()
+	defer primary.mu.Unlock()
+	if len(primary.items) != 0 {
+		t.Error("Missed delete not made once the store recovered:", len(primary.items))
+	}
+}
+
+// downStore is a store whose backend cannot be reached.
+type downStore struct {
+	sessions.Store
+}
+
+func (downStore) Ping(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func Test_FailoverStoreConditional(t *testing.T) {
+	primary := &fakeDynamo{items: make(map[string]map[string]types.AttributeValue)}
+	secondary := &fakeDynamo{items: make(map[string]map[string]types.AttributeValue)}
+	store := failoverstore.New(dynamostore.NewWithClient(primary, "sessions", []byte("secret123")),
+		dynamostore.NewWithClient(secondary, "sessions", []byte("secret123")))
+	n := helloServer(store)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res, req)
+	if len(primary.items) != 1 || len(secondary.items) != 1 {
+		t.Fatal("New session not replicated:", len(primary.items), len(secondary.items))
+	}
+
+	res2 := httptest.NewRecorder()
+	req2, _ := http.NewRequest("GET", "/set", nil)
+	req2.Header.Set("Cookie", requestCookies(res))
+	n.ServeHTTP(res2, req2)
+	if healthy := store.Healthy(); !healthy[0] || !healthy[1] {
+		t.Error("Update not replicated:", healthy)
+	}
+
+	// A replica that lost the session rejects the update and is skipped
+	secondary.items = make(map[string]map[string]types.AttributeValue)
+	store.Breaker(1, time.Minute)
+	res3 := httptest.NewRecorder()
+	n.ServeHTTP(res3, req2)
+	if healthy := store.Healthy(); !healthy[0] || healthy[1] {
+		t.Error("Rejected write not counted as a failure:", healthy)
+	}
+}
+
+func Test_HealthHandler(t *testing.T) {
+	db := &fakeDynamo{items: make(map[string]map[string]types.AttributeValue)}
+	stores := map[string]sessions.Store{
+		"dynamo": dynamostore.NewWithClient(db, "sessions", []byte("secret123")),
+		"cookie": cookiestore.New([]byte("secret123")),
+	}
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	sessions.HealthHandler(stores).ServeHTTP(res, req)
+	var health sessions.Health
+	if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
+		t.Fatal("Decoding health failed:", err)
+	}
+	if res.Code != http.StatusOK || health.Status != "ok" || health.Stores["dynamo"].Status != "ok" ||
+		health.Stores["cookie"].Status != "unknown" {
+		t.Error("Healthy stores reported wrongly:", res.Code, health)
+	}
+
+	stores["redis"] = downStore{cookiestore.New([]byte("secret123"))}
+	res2 := httptest.NewRecorder()
+	sessions.HealthHandler(stores).ServeHTTP(res2, req)
+	if res2.Code != http.StatusServiceUnavailable || !strings.Contains(res2.Body.String(), "connection refused") {
+		t.Error("Unavailable store not reported:", res2.Code, res2.Body.String())
+	}
+}
+
+func Test_HealthHandlerDecorators(t *testing.T) {
+	db := &fakeDynamo{items: make(map[string]map[string]types.AttributeValue)}
+	up := dynamostore.NewWithClient(db, "sessions", []byte("secret123"))
+	down := downStore{cookiestore.New([]byte("secret123"))}
+	async := asyncstore.New(down)
+	defer async.Close(context.Background())
+	failover := failoverstore.New(down, up)
+	shards, _ := shardstore.New(map[string]sessions.Store{"a": up, "b": down})
+
+	stores := map[string]sessions.Store{
+		"metric":   metricstore.New(down, metricstore.NewCollector("health")),
+		"trace":    tracestore.New(down),
+		"cache":    cachestore.New(down, 10, time.Minute),
+		"retry":    retrystore.New(down),
+		"async":    async,
+		"migrate":  migratestore.New(up, down),
+		"shard":    shards,
+		"failover": failover,
+		"cookie":   retrystore.New(cookiestore.New([]byte("secret123"))),
+	}
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	sessions.HealthHandler(stores).ServeHTTP(res, req)
+	var health sessions.Health
+	if err := json.NewDecoder(res.Body).Decode(&health); err != nil {
+		t.Fatal("Decoding health failed:", err)
+	}
+	for name, want := range map[string]string{"metric": "unavailable", "trace": "unavailable",
+		"cache": "unavailable", "retry": "unavailable", "async": "unavailable",
+		"migrate": "unavailable", "shard": "unavailable", "failover": "ok", "cookie": "unknown"} {
+		if got := health.Stores[name].Status; got != want {
+			t.Errorf("Store %s reported %s, not %s", name, got, want)
+		}
+	}
+	if res.Code != http.StatusServiceUnavailable {
+		t.Error("Unavailable wrapped stores not reported:", res.Code)
+	}
+}
+
+// flakyStore fails the first saves it is asked to make.
+type flakyStore struct {
+	sessions.Store
+	failures int
+	saves    int
+}
+
+func (f *flakyStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	f.saves++
+	if f.saves <= f.failures {
+		http.SetCookie(w, &http.Cookie{Name: "partial", Value: "1"})
+		return sessions.NewError("flaky", "save", sessions.ErrBackendUnavailable, nil)
+	}
+	return f.Store.Save(r, w, session)
+}
+
+// blockingStore saves nothing, blocking until the request context is done.
+type blockingStore struct {
+	sessions.Store
+}
+
+func (blockingStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	select {
+	case <-r.Context().Done():
+		return sessions.NewError("blocking", "save", sessions.ErrBackendUnavailable, r.Context().Err())
+	case <-time.After(5 * time.Second):
+		return nil
+	}
+}
+
+func Test_RetryStoreBudget(t *testing.T) {
+	store := retrystore.New(blockingStore{cookiestore.New([]byte("secret123"))},
+		retrystore.WithBudget(50*time.Millisecond))
+	req, _ := http.NewRequest("GET", "/", nil)
+	session := gSessions.NewSession(store, "my_session")
+
+	start := time.Now()
+	err := store.Save(req, httptest.NewRecorder(), session)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("Attempt not bounded by the budget:", elapsed)
+	}
+	if !errors.Is(err, sessions.ErrBackendUnavailable) {
+		t.Error("Timed out save did not fail:", err)
+	}
+}
+
+func Test_RetryStoreNoBudget(t *testing.T) {
+	flaky := &flakyStore{Store: cookiestore.New([]byte("secret123")), failures: 2}
+	store := retrystore.New(flaky, retrystore.WithBudget(0),
+		retrystore.WithBackoff(time.Millisecond, 5*time.Millisecond))
+	req, _ := http.NewRequest("GET", "/", nil)
+	session := gSessions.NewSession(store, "my_session")
+
+	if err := store.Save(req, httptest.NewRecorder(), session); err != nil {
+		t.Error("Save without a budget failed:", err)
+	}
+	if flaky.saves != 3 {
+		t.Error("Save without a budget not retried:", flaky.saves)
+	}
+}
+
+func Test_RetryStore(t *testing.T) {
+	flaky := &flakyStore{Store: cookiestore.New([]byte("secret123")), failures: 2}
+	store := retrystore.New(flaky, retrystore.WithBackoff(time.Millisecond, 5*time.Millisecond))
+	n := helloServer(store)
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/set", nil)
+	n.ServeHTTP(res, req)
+	if flaky.saves != 3 {
+		t.Error("Save not retried until it succeeded:", flaky.saves)
+	}
+	if cookies := requestCookies(res); strings.Contains(cookies, "partial") {
+		t.Error("Cookies of failed attempts not discarded:", cookies)
+	}
+
+	flaky.saves, flaky.failures = 0, 10
+	res2 := httptest.NewRecorder()
+	n.ServeHTTP(res2, req)
+	if flaky.saves != retrystore.DefaultAttempts {
+		t.Error("Save not given up after the set attempts:", flaky.saves)
+	}
+}
+
+func Test_AsyncStore(t *testing.T) {
+	_, addr := startFakeMemcached(t)
+	remote := &countingStore{Store: memcachestore.New([]string{addr}, []byte("secret123"))}
+	store := asyncstore.New(remote, asyncstore.WithWorkers(1), asyncstore.WithBatch(10, time.Hour))
+
+	n := negroni.Classic()
+	n.Use(sessions.Sessions("my_session", store))
+	n.UseHandler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		session := sessions.GetSession(req)
+		count, _ := session.Get("count").(int)
+		session.Set("count", count+1)
+		fmt.Fprint(w, count+1)
+	}))
+
+	res := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/", nil)
+	n.ServeHTTP(res, req)
+	if remote.saves != 1 {
+		t.Fatal("New session not saved synchronously:", remote.saves)
+	}
+
+	req.Header.Set("Cookie", requestCookies(res))
+	for i := 2; i <= 5; i++ {
+		res := httptest.NewRecorder()
+		n.ServeHTTP(res, req)
+		if res.Body.String() != strconv.Itoa(i) {
+			t.Error("Queued values not loaded:", res.Body.String())
+		}
+		if res.Header().Get("Set-Cookie") != "" {
+			t.Error("Cookie set by queued save")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := store.Close(ctx); err != nil {
+		t.Fatal("Queue not drained:", err)
+	}
+	if store.Pending() != 0 {
+		t.Error("Saves left pending:", store.Pending())
+	}
+	if remote.saves >= 5 {
+		t.Error("Queued saves not coalesced:", remote.saves)
+	}
+
+	req2, _ := http.NewRequest("GET", "/", nil)
+	req2.Header = req.Header
+	session, _ := remote.Store.New(req2, "my_session")
+	if session.Values["count"] != 5 {
+		t.Error("Latest values not saved:", session.Values["count"])
+	}
+}
+
+// gatedStore records the values it saves by session ID, holding saves of
+// sessions with a "block" value until release is closed.
+type gatedStore struct {
+	sessions.Store
+	mu      sync.Mutex
+	saved   map[string]interface{}
+	started chan struct{}
+	release chan struct{}
+}
+
+func (g *gatedStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	if session.Values["block"] == true {
+		g.started <- struct{}{}
+		<-g.release
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.saved[session.ID] = session.Values["v"]
+	return nil
+}
+
+func Test_AsyncStoreSyncAfterQueued(t *testing.T) {
+	gated := &gatedStore{
+		Store:   cookiestore.New([]byte("secret123")),
+		saved:   make(map[string]interface{}),
+		started: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	store := asyncstore.New(gated, asyncstore.WithWorkers(1), asyncstore.WithBatch(1, 0),
+		asyncstore.WithQueueSize(1, asyncstore.SaveSync))
+	save := func(id string, v int, block bool) error {
+		session := gSessions.NewSession(store, "my_session")
+		session.ID, session.Options = id, &gSessions.Options{MaxAge: 3600}
+		session.Values["v"] = v
+		if block {
+			session.Values["block"] = true
+		}
+		req, _ := http.NewRequest("GET", "/", nil)
+		return store.Save(req, httptest.NewRecorder(), session)
+	}
+
+	save("a", 1, true)
+	<-gated.started
+	// Fill the queue, so that the next save is made synchronously
+	save("b", 1, false)
+	done := make(chan struct{})
+	go func() {
+		save("a", 2, false)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Error("Synchronous save did not wait for the save in flight")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(gated.release)
+	<-done
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := store.Close(ctx); err != nil {
+		t.Fatal("Queue not drained:", err)
+	}
+	if v := gated.saved["a"]; v != 2 {
+		t.Error("Synchronous save overwritten by queued save:", v)
+	}
+}
+
+// rekeyingStore moves sessions with a "legacy" ID to a new ID when saving
+// them, setting a new cookie.
+type rekeyingStore struct {
+	sessions.Store
+}
+
+func (rekeyingStore) Rekeys(session *gSessions.Session) bool {
+	return strings.HasPrefix(session.ID, "legacy")
+}
+
+func (rekeyingStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	session.ID = "moved"
+	http.SetCookie(w, &http.Cookie{Name: session.Name(), Value: session.ID})
+	return nil
+}
+
+func Test_AsyncStoreRekey(t *testing.T) {
+	store := asyncstore.New(rekeyingStore{cookiestore.New([]byte("secret123"))})
+	defer store.Close(context.Background())
+
+	session := gSessions.NewSession(store, "my_session")
+	session.ID, session.Options = "legacy1", &gSessions.Options{MaxAge: 3600}
+	req, _ := http.NewRequest("GET", "/", nil)
+	res := httptest.NewRecorder()
+	if err := store.Save(req, res, session); err != nil {
+		t.Fatal("Saving failed:", err)
+	}
+	if cookie := res.Header().Get("Set-Cookie"); cookie != "my_session=moved" {
+		t.Error("Cookie of a session moved to a new ID not set:", cookie)
+	}
+	if store.Pending() != 0 {
+		t.Error("Session moving to a new ID queued:", store.Pending())
+	}
+}
+
+type fakeCleaner struct {
+	mu      sync.Mutex
+	befores []time.Time
+}
+
+func (c *fakeCleaner) DeleteExpired(ctx context.Context, before time.Time) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.befores = append(c.befores, before)
+	return 3, nil
+}
+
+func (c *fakeCleaner) sweeps() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.befores)
+}
+
+func Test_Sweeper(t *testing.T) {
+	cleaner := &fakeCleaner{}
+	unset := &sessions.Sweeper{Cleaner: cleaner}
+	if _, err := unset.Sweep(context.Background()); err != sessions.ErrNoMaxAge {
+		t.Error("Sweep without MaxAge not refused:", err)
+	}
+	if err := unset.Run(context.Background()); err != sessions.ErrNoMaxAge {
+		t.Error("Run without MaxAge not refused:", err)
+	}
+	if cleaner.sweeps() != 0 {
+		t.Error("Swept without MaxAge:", cleaner.befores)
+	}
+
+	leader := false
+	sweeper := &sessions.Sweeper{
+		Cleaner: cleaner,
+		MaxAge:  time.Hour,
+		Leader: func(ctx context.Context) (bool, error) {
+			return leader, nil
+		},
+	}
+
+	if deleted, err := sweeper.Sweep(context.Background()); deleted != 0 || err != nil || cleaner.sweeps() != 0 {
+		t.Error("Swept without being leader:", deleted, err)
+	}
+	leader = true
+	if deleted, err := sweeper.Sweep(context.Background()); deleted != 3 || err != nil {
+		t.Error("Sweep failed:", deleted, err)
+	}
+	if age := time.Since(cleaner.befores[0]); age < time.Hour || age > time.Hour+time.Minute {
+		t.Error("Sessions not expired after MaxAge:", age)
+	}
+
+	sweeper.Interval, sweeper.Jitter = time.Millisecond, time.Millisecond
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		sweeper.Run(ctx)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for cleaner.sweeps() < 3 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	<-done
+	if cleaner.sweeps() < 3 {
+		t.Error("Sweeps not run periodically:", cleaner.sweeps())
+	}
+}
@@ -0,0 +1,185 @@
+// Package shardstore spreads sessions across several stores by consistent
+// hashing on their ID.
+package shardstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	"github.com/goincremental/negroni-sessions/internal/hashring"
+	"github.com/goincremental/negroni-sessions/internal/writer"
+	gSessions "github.com/gorilla/sessions"
+)
+
+// shardKey is the session value key under which the name of the shard
+// holding a session is kept while it is in memory. It is never stored.
+const shardKey = "_shard"
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// New returns a store that keeps each session in one of shards, chosen by
+// consistent hashing on the session ID when the session is created.
+//
+// The cookie of a session is prefixed with the name of its shard, so that
+// it is loaded from that shard alone, and stays there when shards are
+// added. Adding a shard only sends a share of new sessions to it; removing
+// one loses the sessions it held. Shard names must be made of letters,
+// digits, '-' and '_', and each shard must keep the session ID in a cookie
+// named after the session, as the server-side stores do.
+func New(shards map[string]nSessions.Store) (nSessions.Store, error) {
+	if len(shards) == 0 {
+		return nil, errors.New("shardstore: no shards")
+	}
+	names := make([]string, 0, len(shards))
+	for name := range shards {
+		if !validName.MatchString(name) {
+			return nil, errors.New("shardstore: invalid shard name " + name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return &shardStore{
+		shards: shards,
+		names:  names,
+		ring:   hashring.New(names, 0),
+		ids:    nSessions.DefaultIDGenerator,
+	}, nil
+}
+
+type shardStore struct {
+	shards map[string]nSessions.Store
+	names  []string
+	ring   *hashring.Ring
+	ids    nSessions.IDGenerator
+}
+
+func (s *shardStore) Options(options nSessions.Options) {
+	for _, shard := range s.shards {
+		shard.Options(options)
+	}
+}
+
+// IDGenerator sets the generator of new session IDs, which are assigned
+// before a session is saved so that its shard can be chosen.
+func (s *shardStore) IDGenerator(ids nSessions.IDGenerator) {
+	s.ids = ids
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (s *shardStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(s, name)
+}
+
+// Ping checks the backend of every shard, as the sessions held by a shard
+// that is down cannot be loaded.
+func (s *shardStore) Ping(ctx context.Context) error {
+	supported := false
+	for _, name := range s.names {
+		err := nSessions.Ping(ctx, s.shards[name])
+		switch {
+		case errors.Is(err, nSessions.ErrPingUnsupported):
+			continue
+		case err != nil:
+			return fmt.Errorf("shardstore: shard %s: %w", name, err)
+		}
+		supported = true
+	}
+	if !supported {
+		return nSessions.ErrPingUnsupported
+	}
+	return nil
+}
+
+// New loads the session from the shard named in its cookie, or returns a
+// new session.
+func (s *shardStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	if cookie, err := r.Cookie(name); err == nil {
+		if hint, token, ok := strings.Cut(cookie.Value, "."); ok && s.shards[hint] != nil {
+			session, err := s.shards[hint].New(withCookie(r, name, token), name)
+			if err == nil && session != nil && !session.IsNew {
+				session.Values[shardKey] = hint
+				return session, nil
+			}
+			if session != nil {
+				return s.reset(session), err
+			}
+			return nil, err
+		}
+	}
+
+	// Without a valid hint the cookie belongs to no shard, so it is removed
+	// and a new session is created by any shard
+	shard := s.shards[s.ring.Get("")]
+	session, err := shard.New(withCookie(r, name, ""), name)
+	if session == nil {
+		return nil, err
+	}
+	return s.reset(session), err
+}
+
+// reset clears the ID and shard of a new session, which are assigned when
+// it is first saved.
+func (s *shardStore) reset(session *gSessions.Session) *gSessions.Session {
+	session.ID = ""
+	delete(session.Values, shardKey)
+	return session
+}
+
+// Save saves the session to its shard, prefixing the shard's cookie with
+// the shard name.
+func (s *shardStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	hint, _ := session.Values[shardKey].(string)
+	if s.shards[hint] == nil {
+		if session.ID == "" {
+			id, err := s.ids.NewID()
+			if err != nil {
+				return nSessions.WrapError("shardstore", "save", err)
+			}
+			session.ID = id
+		}
+		hint = s.ring.Get(session.ID)
+	}
+
+	delete(session.Values, shardKey)
+	header := make(http.Header)
+	err := s.shards[hint].Save(r, writer.Headers(w, header), session)
+	session.Values[shardKey] = hint
+
+	for key, values := range header {
+		if key == "Set-Cookie" {
+			continue
+		}
+		w.Header()[key] = append(w.Header()[key], values...)
+	}
+	for _, cookie := range (&http.Response{Header: header}).Cookies() {
+		if cookie.Name == session.Name() && cookie.Value != "" {
+			cookie.Value = hint + "." + cookie.Value
+		}
+		http.SetCookie(w, cookie)
+	}
+	return err
+}
+
+// withCookie returns a copy of r whose cookie called name has the given
+// value, or is left out if value is empty.
+func withCookie(r *http.Request, name, value string) *http.Request {
+	r2 := r.Clone(r.Context())
+	r2.Header.Del("Cookie")
+	for _, cookie := range r.Cookies() {
+		if cookie.Name == name {
+			if value == "" {
+				continue
+			}
+			cookie.Value = value
+		}
+		r2.AddCookie(cookie)
+	}
+	return r2
+}
@@ -0,0 +1,113 @@
+package sessions
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"time"
+)
+
+// Cleaner is implemented by stores that can delete expired sessions
+// themselves, for backends that do not expire data on their own.
+type Cleaner interface {
+	// DeleteExpired deletes the sessions last saved before the given time,
+	// returning how many were deleted.
+	DeleteExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// DefaultSweepInterval is how often a Sweeper sweeps unless set otherwise.
+var DefaultSweepInterval = time.Hour
+
+// ErrNoMaxAge is returned by a Sweeper whose MaxAge is not set, which would
+// otherwise delete every session.
+var ErrNoMaxAge = errors.New("session: sweeper MaxAge must be positive")
+
+// Sweeper periodically deletes expired sessions from a Cleaner.
+type Sweeper struct {
+	// Cleaner is the store to sweep.
+	Cleaner Cleaner
+	// MaxAge is how long after they were last saved sessions are deleted.
+	// It should match the MaxAge the store's sessions are given, and must
+	// be set.
+	MaxAge time.Duration
+	// Interval is the time between sweeps. It defaults to
+	// DefaultSweepInterval.
+	Interval time.Duration
+	// Jitter is the most time added at random to each interval, so that
+	// nodes started together do not sweep together.
+	Jitter time.Duration
+	// Leader, if set, is called before each sweep, which is skipped unless
+	// it reports that this node should sweep, e.g. because it holds a lease
+	// or an advisory lock. Without it every node sweeps.
+	Leader func(ctx context.Context) (bool, error)
+	// Logger receives failed sweeps, and the number of sessions deleted by
+	// each sweep at debug level. Nothing is logged until it is set.
+	Logger *slog.Logger
+}
+
+// Run sweeps once each interval until ctx is done. It returns ErrNoMaxAge
+// at once if MaxAge is not set.
+func (s *Sweeper) Run(ctx context.Context) error {
+	if s.MaxAge <= 0 {
+		return ErrNoMaxAge
+	}
+	interval := s.Interval
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	for {
+		wait := interval
+		if s.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(s.Jitter)))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		s.Sweep(ctx)
+	}
+}
+
+// Sweep deletes the expired sessions now, unless Leader reports that
+// another node sweeps. It returns the number of sessions deleted.
+func (s *Sweeper) Sweep(ctx context.Context) (int, error) {
+	if s.MaxAge <= 0 {
+		return 0, ErrNoMaxAge
+	}
+	if s.Leader != nil {
+		leader, err := s.Leader(ctx)
+		if err != nil {
+			s.log(ctx, slog.LevelError, "session sweep leader check failed", slog.Any("error", err))
+			return 0, err
+		}
+		if !leader {
+			return 0, nil
+		}
+	}
+
+	start := time.Now()
+	deleted, err := s.Cleaner.DeleteExpired(ctx, start.Add(-s.MaxAge))
+	if err != nil {
+		s.log(ctx, slog.LevelError, "session sweep failed",
+			slog.Int("deleted", deleted),
+			slog.String("error_class", ErrorClass(err)),
+			slog.Any("error", err),
+		)
+		return deleted, err
+	}
+	s.log(ctx, slog.LevelDebug, "session sweep finished",
+		slog.Int("deleted", deleted),
+		slog.Duration("duration", time.Since(start)),
+	)
+	return deleted, nil
+}
+
+func (s *Sweeper) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if s.Logger != nil {
+		s.Logger.LogAttrs(ctx, level, msg, attrs...)
+	}
+}
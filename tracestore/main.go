@@ -0,0 +1,135 @@
+// Package tracestore wraps a session store so that loading, saving and
+// deleting sessions show up as OpenTelemetry spans in request traces.
+package tracestore
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	nSessions "github.com/goincremental/negroni-sessions"
+	gSessions "github.com/gorilla/sessions"
+	"github.com/urfave/negroni"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/goincremental/negroni-sessions/tracestore"
+
+// Attribute keys set on session spans.
+const (
+	StoreKey       = attribute.Key("session.store")
+	NameKey        = attribute.Key("session.name")
+	PayloadSizeKey = attribute.Key("session.payload_size")
+	OutcomeKey     = attribute.Key("session.outcome")
+	IsNewKey       = attribute.Key("session.is_new")
+)
+
+// Option configures a tracing store.
+type Option func(*traceStore)
+
+// WithTracerProvider sets the provider spans are created from. The global
+// provider is used by default.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(t *traceStore) {
+		t.tracer = provider.Tracer(instrumentationName)
+	}
+}
+
+// WithStoreType sets the value of the session.store attribute, which
+// defaults to the Go type of the wrapped store.
+func WithStoreType(storeType string) Option {
+	return func(t *traceStore) {
+		t.storeType = storeType
+	}
+}
+
+// New returns a store that records a span for every operation of store,
+// as a child of the span in the request context.
+func New(store nSessions.Store, options ...Option) nSessions.Store {
+	t := &traceStore{
+		Store:     store,
+		tracer:    otel.GetTracerProvider().Tracer(instrumentationName),
+		storeType: fmt.Sprintf("%T", store),
+	}
+	for _, option := range options {
+		option(t)
+	}
+	return t
+}
+
+// Sessions is the Sessions middleware with tracing of the given store.
+func Sessions(name string, store nSessions.Store, options ...Option) negroni.HandlerFunc {
+	return nSessions.Sessions(name, New(store, options...))
+}
+
+type traceStore struct {
+	nSessions.Store
+	tracer    trace.Tracer
+	storeType string
+}
+
+// Get registers and returns a session for the given name and session store.
+// It returns a new session if there are no sessions registered for the name.
+func (t *traceStore) Get(r *http.Request, name string) (*gSessions.Session, error) {
+	return gSessions.GetRegistry(r).Get(t, name)
+}
+
+// Ping checks the backend of the wrapped store.
+func (t *traceStore) Ping(ctx context.Context) error {
+	return nSessions.Ping(ctx, t.Store)
+}
+
+// New loads a session from the wrapped store inside a session.load span.
+func (t *traceStore) New(r *http.Request, name string) (*gSessions.Session, error) {
+	r, span := t.start(r, "session.load", name)
+	defer span.End()
+
+	session, err := t.Store.New(r, name)
+	if session != nil {
+		span.SetAttributes(IsNewKey.Bool(session.IsNew))
+	}
+	end(span, err)
+	return session, err
+}
+
+// Save saves the session to the wrapped store inside a session.save span,
+// or a session.delete span if the session is being removed.
+func (t *traceStore) Save(r *http.Request, w http.ResponseWriter, session *gSessions.Session) error {
+	op := "session.save"
+	if session.Options != nil && session.Options.MaxAge < 0 {
+		op = "session.delete"
+	}
+	r, span := t.start(r, op, session.Name())
+	defer span.End()
+
+	if size, ok := nSessions.PayloadSize(session.Values); ok {
+		span.SetAttributes(PayloadSizeKey.Int(size))
+	}
+	err := t.Store.Save(r, w, session)
+	end(span, err)
+	return err
+}
+
+// start starts a span for op, returning it with r carrying it in its
+// context so that spans of the wrapped store's backend nest under it.
+func (t *traceStore) start(r *http.Request, op, name string) (*http.Request, trace.Span) {
+	ctx, span := t.tracer.Start(r.Context(), op,
+		trace.WithSpanKind(trace.SpanKindInternal),
+		trace.WithAttributes(StoreKey.String(t.storeType), NameKey.String(name)))
+	return r.WithContext(ctx), span
+}
+
+// end records the outcome of an operation on span, as the error class given
+// by sessions.ErrorClass or "ok" on success.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(OutcomeKey.String(nSessions.ErrorClass(err)))
+		return
+	}
+	span.SetAttributes(OutcomeKey.String("ok"))
+}